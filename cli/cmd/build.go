@@ -11,6 +11,7 @@ import (
 
 	"gostatic/pkg/builder"
 	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/transformer"
 
 	"github.com/spf13/cobra"
 	yaml "gopkg.in/yaml.v3"
@@ -50,8 +51,8 @@ func getConfigurationPath(argPath string, configName string) (string, error) {
 	}
 }
 
-func readBuildConfiguration(path string) ([]builder.BuildSection, error) {
-	var config []builder.BuildSection
+func readBuildConfiguration(path string) (builder.Site, error) {
+	var config builder.Site
 	bytes, err := os.ReadFile(path)
 	if err != nil {
 		return config, err
@@ -77,12 +78,8 @@ func runBuild(ctx context.Context, wg *sync.WaitGroup, buildPath string) {
 	rootPath := filepath.Dir(buildPath)
 
 	logger.Println("rebuilding...")
-	for i := range config {
-		section := config[i]
-		err := section.Build(ctx, rootPath)
-		if err != nil {
-			logger.Println("build error:", err)
-		}
+	if err := config.Build(ctx, rootPath); err != nil {
+		logger.Println("build error:", err)
 	}
 
 	wg.Done()
@@ -97,7 +94,10 @@ var buildCmd = &cobra.Command{
 
 A transformation is a name and some arguments separated by ':'.
 
-Transformations: template, bundle, banner.
+Transformations: template, bundle, banner, images, security, toc, highlight, links, lint, validate, components, gotemplate, script, i18n.
+Programs declared under 'plugins' add transformations named after them.
+
+Steps listed under 'after' run once when all sections are built: fingerprint, security, sitemap, feed, search-index, linkcheck.
 `,
 	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -123,6 +123,7 @@ Transformations: template, bundle, banner.
 		serveFiles := cmd.Flags().Lookup("serve").Changed
 
 		if watchFiles || serveFiles {
+			ctx = context.WithValue(ctx, builder_context.DependenciesContextKey, transformer.NewDependencies())
 			watchCtx, _ := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
 			runner := func(ctx context.Context) {
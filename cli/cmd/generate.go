@@ -9,6 +9,8 @@ import (
 	"syscall"
 
 	"gostatic/pkg/builder"
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/transformer"
 
 	"github.com/spf13/cobra"
 )
@@ -44,13 +46,21 @@ The arguments to the generate command matches keys of build.yaml configuration f
 
 		rootPath := "."
 
-		section := builder.BuildSection{inPath, outPath, ((builder.Pipeline)(pipeline))}
+		section := builder.NewBuildSection(inPath, outPath, ((builder.Pipeline)(pipeline)))
 
 		wg := new(sync.WaitGroup)
 
+		dependencies := transformer.NewDependencies()
+		ctx = context.WithValue(ctx, builder_context.DependenciesContextKey, dependencies)
+
 		runner := func(ctx context.Context) {
 			logger.Println("rebuilding")
-			if err := section.Build(ctx, rootPath); err != nil {
+			dependencies.Reset()
+			state := transformer.NewBuildState()
+			ctx = context.WithValue(ctx, builder_context.StateContextKey, state)
+			err := section.Build(ctx, rootPath)
+			state.Close()
+			if err != nil {
 				logger.Fatal(err)
 			}
 		}
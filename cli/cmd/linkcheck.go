@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/transformer"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	listExternal bool
+	ignoreLinks  []string
+)
+
+var linkcheckCmd = &cobra.Command{
+	Use:   "linkcheck",
+	Short: "Check the links of built HTML files",
+	Long: `Check that the internal href, src and srcset links and #fragment anchors of every HTML file
+in the directory named on the command line (the current directory by default) point to files and
+elements that exist.
+
+Broken links are reported with the page and the path of the element. The command exits with
+status 1 when a link is broken. Links to other sites are never fetched, --external lists them.
+
+Add 'linkcheck' to the 'after' steps of build.yaml to check the links of every build.
+`,
+	Example: "gostatic linkcheck --external build/",
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+
+		logger := ctx.Value(builder_context.LoggerContextKey).(*log.Logger)
+		logger.SetPrefix("🔗 ")
+
+		outRoot := "."
+		if len(args) > 0 {
+			outRoot = args[0]
+		}
+		outRoot, err := filepath.Abs(outRoot)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		diagnostics := transformer.NewDiagnostics(logger)
+		ctx = context.WithValue(ctx, builder_context.DiagnosticsContextKey, diagnostics)
+		checker := transformer.NewLinkChecker(ctx, outRoot, transformer.LinkCheckConfig{Ignore: ignoreLinks})
+
+		pages := 0
+		err = filepath.WalkDir(outRoot, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || filepath.Ext(path) != ".html" {
+				return nil
+			}
+			pages++
+			return checker.Check(path)
+		})
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		if listExternal {
+			for _, link := range checker.External() {
+				logger.Println("external:", link)
+			}
+		}
+		logger.Printf("%d page(s), %d broken link(s)\n", pages, checker.Broken())
+		if checker.Broken() > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(linkcheckCmd)
+
+	linkcheckCmd.Flags().BoolVarP(&listExternal, "external", "e", false, "List links to other sites")
+	linkcheckCmd.Flags().StringSliceVarP(&ignoreLinks, "ignore", "i", []string{}, "Glob patterns of links not checked")
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/transformer"
 	"io/fs"
 	"log"
 	"os"
@@ -24,9 +25,9 @@ func collectOutputPaths(buildPath string) ([]string, error) {
 	}
 
 	rootPath := filepath.Dir(buildPath)
-	paths := make([]string, len(config))
-	for i := range config {
-		path := filepath.Join(rootPath, config[i].Out)
+	paths := make([]string, len(config.Sections))
+	for i := range config.Sections {
+		path := filepath.Join(rootPath, config.Sections[i].Out)
 		paths[i] = path
 	}
 
@@ -99,6 +100,23 @@ func collectInputPaths(buildPath string) ([]string, error) {
 	return inputPaths, nil
 }
 
+// watchDependencies adds the directories of the files pages depend on, like
+// components, to the watcher.
+func watchDependencies(watcher *fsnotify.Watcher, dependencies *transformer.Dependencies, watched map[string]bool) {
+	if dependencies == nil {
+		return
+	}
+	for _, path := range dependencies.Files() {
+		dir := filepath.Dir(path)
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err == nil {
+			watched[dir] = true
+		}
+	}
+}
+
 func runWatcher(ctx context.Context, wg *sync.WaitGroup, filePaths []string, matchPatterns []string, buildFunc func(context.Context)) {
 	var (
 		watcher *fsnotify.Watcher
@@ -107,6 +125,8 @@ func runWatcher(ctx context.Context, wg *sync.WaitGroup, filePaths []string, mat
 	)
 
 	logger := ctx.Value(builder_context.LoggerContextKey).(*log.Logger)
+	dependencies, _ := ctx.Value(builder_context.DependenciesContextKey).(*transformer.Dependencies)
+	watched := make(map[string]bool)
 
 	watcher, err = fsnotify.NewWatcher()
 	if err != nil {
@@ -119,7 +139,11 @@ func runWatcher(ctx context.Context, wg *sync.WaitGroup, filePaths []string, mat
 			logger.Println(err)
 			goto WatchDone
 		}
+		if path, err := filepath.Abs(filePaths[i]); err == nil {
+			watched[path] = true
+		}
 	}
+	watchDependencies(watcher, dependencies, watched)
 
 	logger.Println("starting watch")
 	for {
@@ -147,7 +171,11 @@ func runWatcher(ctx context.Context, wg *sync.WaitGroup, filePaths []string, mat
 				continue
 			}
 
-			if !includePathMatch(matchPatterns, event.Name) {
+			dependents := []string{}
+			if dependencies != nil {
+				dependents = dependencies.Dependents(event.Name)
+			}
+			if !includePathMatch(matchPatterns, event.Name) && len(dependents) == 0 {
 				continue
 			}
 
@@ -168,8 +196,13 @@ func runWatcher(ctx context.Context, wg *sync.WaitGroup, filePaths []string, mat
 			}
 
 			if rebuild {
-				logger.Println("change", event.Name)
+				if len(dependents) > 0 {
+					logger.Println("change", event.Name, "used by", strings.Join(dependents, ", "))
+				} else {
+					logger.Println("change", event.Name)
+				}
 				buildFunc(ctx)
+				watchDependencies(watcher, dependencies, watched)
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
@@ -203,6 +236,7 @@ A project directory is a directory with a build.yaml file.
 		}
 
 		wg := new(sync.WaitGroup)
+		ctx = context.WithValue(ctx, builder_context.DependenciesContextKey, transformer.NewDependencies())
 		watchCtx, _ := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
 		inputPaths, err := collectInputPaths(buildPath)
@@ -0,0 +1,14 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:output method="html"/>
+  <xsl:param name="version"/>
+  <xsl:template match="/release-badge">
+    <span class="badge">
+      <xsl:choose>
+        <xsl:when test="starts-with($version, '0.')">preview </xsl:when>
+        <xsl:otherwise>stable </xsl:otherwise>
+      </xsl:choose>
+      <xsl:value-of select="$version"/>
+    </span>
+  </xsl:template>
+</xsl:stylesheet>
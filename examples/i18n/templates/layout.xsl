@@ -0,0 +1,29 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<xsl:stylesheet version="1.0"
+    xmlns:xsl="http://www.w3.org/1999/XSL/Transform"
+    xmlns:g="https://github.com/esdinb/gostatic/#xslt-extensions"
+    extension-element-prefixes="g">
+  <xsl:output method="html"/>
+  <xsl:param name="locale" select="'en'"/>
+
+  <xsl:template match="body">
+    <body>
+      <header>
+        <strong><xsl:value-of select="g:translate('site.name')"/></strong>
+        <nav>
+          <a href="index.html"><xsl:value-of select="g:translate('nav.home')"/></a>
+          <a href="about.html"><xsl:value-of select="g:translate('nav.about')"/></a>
+        </nav>
+        <small><xsl:value-of select="$locale"/></small>
+      </header>
+      <xsl:apply-templates select="node()"/>
+      <footer><xsl:value-of select="g:translate('footer.note', '© Example Books')"/></footer>
+    </body>
+  </xsl:template>
+
+  <xsl:template match="@*|node()">
+    <xsl:copy>
+      <xsl:apply-templates select="@*|node()"/>
+    </xsl:copy>
+  </xsl:template>
+</xsl:stylesheet>
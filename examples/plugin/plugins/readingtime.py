@@ -0,0 +1,49 @@
+#!/usr/bin/env python3
+"""Adds the reading time of a page after its first heading.
+
+A gostatic plugin: JSON-RPC 2.0 messages, one per line, on stdin and stdout.
+"""
+import json
+import math
+import re
+import sys
+
+words_per_minute = 200
+if "--words-per-minute" in sys.argv:
+    words_per_minute = int(sys.argv[sys.argv.index("--words-per-minute") + 1])
+
+
+def send(message):
+    sys.stdout.write(json.dumps(message) + "\n")
+    sys.stdout.flush()
+
+
+def transform(params):
+    document = params["document"]
+    body = re.search(r"<body[^>]*>(.*)</body>", document, re.S)
+    text = re.sub(r"<[^>]+>", " ", body.group(1) if body else document)
+    words = len(text.split())
+    if words < 20:
+        return {
+            "diagnostics": [
+                {"severity": "warning", "message": "page has only %d words" % words}
+            ]
+        }
+    unit = params["args"][0] if params["args"] else "minutes"
+    minutes = math.ceil(words / words_per_minute)
+    note = '<p class="reading-time">%d %s</p>' % (minutes, unit)
+    document = re.sub(r"(</h1>)", r"\1" + note, document, count=1)
+    send({"jsonrpc": "2.0", "method": "log", "params": {"message": "%s: %d words" % (params["inPath"], words)}})
+    return {"document": document}
+
+
+for line in sys.stdin:
+    request = json.loads(line)
+    if request["method"] == "initialize":
+        result = {"protocol": 1, "name": "readingtime"}
+    elif request["method"] == "transform":
+        result = transform(request["params"])
+    else:
+        send({"jsonrpc": "2.0", "id": request["id"], "error": {"code": -32601, "message": "unknown method"}})
+        continue
+    send({"jsonrpc": "2.0", "id": request["id"], "result": result})
@@ -0,0 +1,25 @@
+// External links open in a new tab.
+document.select("a[href^='http']").forEach(function (link) {
+  link.setAttribute("target", "_blank");
+  link.setAttribute("rel", "noopener");
+});
+
+// Headings get an id and a table of contents is added after the title.
+var list = document.createElement("ul");
+document.query("//h2").forEach(function (heading) {
+  var id = heading.text().toLowerCase().replace(/[^a-z0-9]+/g, "-");
+  heading.setAttribute("id", id);
+  var link = document.createElement("a");
+  link.setAttribute("href", "#" + id);
+  link.append(heading.text());
+  var item = document.createElement("li");
+  item.append(link);
+  list.append(item);
+});
+document.first("h1").after(list);
+
+document.select("img").forEach(function (image) {
+  if (!image.hasAttribute("alt")) {
+    console.warn("image without alt text:", image.getAttribute("src"));
+  }
+});
@@ -0,0 +1,6 @@
+// The version is read from a file of the project.
+var version = readFile("VERSION").trim();
+var footer = document.createElement("footer");
+footer.setText(build.args + " " + version);
+document.first("body").append(footer);
+console.log("stamped", build.inPath, "with", version);
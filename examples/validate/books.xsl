@@ -0,0 +1,20 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:output method="html"/>
+  <xsl:template match="/books">
+    <html lang="en">
+      <head>
+        <meta charset="utf-8"/>
+        <title>Books</title>
+      </head>
+      <body>
+        <h1>Books</h1>
+        <ul>
+          <xsl:for-each select="book">
+            <li><xsl:value-of select="title"/> (<xsl:value-of select="@year"/>), <xsl:value-of select="author"/></li>
+          </xsl:for-each>
+        </ul>
+      </body>
+    </html>
+  </xsl:template>
+</xsl:stylesheet>
@@ -6,6 +6,7 @@ require (
 	github.com/CannibalVox/cgoalloc v1.2.1
 	github.com/alecthomas/chroma v0.10.0
 	github.com/common-nighthawk/go-figure v0.0.0-20210622060536-734e95fb86be
+	github.com/dop251/goja v0.0.0-20230605162241-28ee0ee714f3
 	github.com/evanw/esbuild v0.19.4
 	github.com/fsnotify/fsnotify v1.6.0
 	github.com/jchenry/goldmark-pikchr v0.1.0
@@ -15,6 +16,7 @@ require (
 	github.com/yuin/goldmark v1.5.6
 	github.com/yuin/goldmark-highlighting v0.0.0-20220208100518-594be1970594
 	go.abhg.dev/goldmark/frontmatter v0.1.0
+	golang.org/x/image v0.12.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -22,12 +24,13 @@ require (
 	github.com/BurntSushi/toml v1.2.1 // indirect
 	github.com/dlclark/regexp2 v1.8.1 // indirect
 	github.com/gebv/pikchr v1.0.2 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/kr/pretty v0.3.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
 	golang.org/x/sys v0.11.0 // indirect
-	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	golang.org/x/text v0.13.0 // indirect
 )
 
 replace github.com/jbussdieker/golibxml => ../golibxml
@@ -156,7 +156,20 @@ func htmlFormatter(ctx context.Context) error {
 		}
 	}
 
-	saveCtx = markup.NewHTML5Serializer(bufio.NewWriter(outFile))
+	if options, ok := ctx.Value(builder_context.SerializerOptionsContextKey).(markup.HTML5SerializerOptions); ok {
+		saveCtx = markup.NewHTML5SerializerWithOptions(bufio.NewWriter(outFile), options)
+		// steps rewriting the file after the build serialize it the same way
+		outPath, _ := ctx.Value(builder_context.OutPathContextKey).(string)
+		if manifest, ok := ctx.Value(builder_context.ManifestContextKey).(*transformer.Manifest); ok && outPath != "" {
+			inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+			if absInPath, err := filepath.Abs(inPath); err == nil {
+				inPath = absInPath
+			}
+			manifest.SetSerializerOptions(inPath, outPath, options)
+		}
+	} else {
+		saveCtx = markup.NewHTML5Serializer(bufio.NewWriter(outFile))
+	}
 	if saveCtx == nil {
 		return errors.New("failed to create html formatter context")
 	}
@@ -265,14 +278,19 @@ func (b *BuildTransformation) Parse() BuildCommand {
 	return BuildCommand{parts[0], parts[1:]}
 }
 
+// BuildSection builds the files matching In into Out. A section with Locales
+// is built once for each of them.
 type BuildSection struct {
 	In       string
 	Out      string
 	Pipeline Pipeline
+	Locales  []string
+
+	localization *transformer.Localization
 }
 
 func NewBuildSection(in string, out string, pipeline Pipeline) BuildSection {
-	return BuildSection{in, out, pipeline}
+	return BuildSection{In: in, Out: out, Pipeline: pipeline}
 }
 
 func (p *Pipeline) Transform(ctx context.Context) (context.Context, error) {
@@ -282,6 +300,9 @@ func (p *Pipeline) Transform(ctx context.Context) (context.Context, error) {
 	for _, command := range *p {
 		cmd := command.Parse()
 		fn := transformer.Registry.Lookup(cmd.Name)
+		if plugins, ok := ctx.Value(builder_context.PluginsContextKey).(*transformer.Plugins); ok && fn == nil {
+			fn = plugins.Lookup(cmd.Name)
+		}
 		if fn == nil {
 			return ctx, errors.New(fmt.Sprintf("unknown transform name: %s", cmd.Name))
 		}
@@ -316,6 +337,46 @@ func (b *BuildSection) ProcessFile(ctx context.Context) error {
 	return nil
 }
 
+// Plan adds the files the section builds to plan without building them.
+func (b *BuildSection) Plan(plan *transformer.Plan, rootPath string) error {
+	if b.In == "-" || b.Out == "-" {
+		return nil
+	}
+	rootPathAbsolute, err := filepath.Abs(rootPath)
+	if err != nil {
+		return err
+	}
+	absPath := filepath.Join(rootPathAbsolute, b.Out)
+	outPathIsDir := strings.HasSuffix(b.Out, string(os.PathSeparator))
+	if info, err := os.Stat(absPath); err == nil && info.IsDir() {
+		outPathIsDir = true
+	}
+
+	matches, err := filepath.Glob(filepath.Join(rootPath, b.In))
+	if err != nil {
+		return err
+	}
+	for _, inPath := range matches {
+		if info, err := os.Stat(inPath); err != nil || info.IsDir() {
+			continue
+		}
+		outPath := absPath
+		if outPathIsDir {
+			relPath, err := filepath.Rel(rootPath, inPath)
+			if err != nil {
+				return err
+			}
+			outPath = filepath.Join(absPath, relPath)
+		}
+		absInPath, err := filepath.Abs(inPath)
+		if err != nil {
+			return err
+		}
+		plan.Add(rootPathAbsolute, absInPath, outPath)
+	}
+	return nil
+}
+
 func (b *BuildSection) Build(ctx context.Context, rootPath string) error {
 	var (
 		outFile   *os.File      = nil
@@ -332,6 +393,10 @@ func (b *BuildSection) Build(ctx context.Context, rootPath string) error {
 	ctx = context.WithValue(ctx, builder_context.RootPathContextKey, rootPathAbsolute)
 	ctx = context.WithValue(ctx, builder_context.ParamsContextKey, []string{})
 	ctx = context.WithValue(ctx, builder_context.StringParamsContextKey, []string{"basePath", rootPath})
+	if b.localization != nil {
+		ctx = context.WithValue(ctx, builder_context.LocalizationContextKey, b.localization)
+		ctx = context.WithValue(ctx, builder_context.StringParamsContextKey, []string{"basePath", rootPath, "locale", b.localization.Locale})
+	}
 
 	if logger, ok := ctx.Value(builder_context.LoggerContextKey).(*log.Logger); ok {
 		handle := cgo.NewHandle(logger)
@@ -451,6 +516,12 @@ func (b *BuildSection) Build(ctx context.Context, rootPath string) error {
 			if err != nil {
 				return err
 			}
+
+			if manifest, ok := ctx.Value(builder_context.ManifestContextKey).(*transformer.Manifest); ok && outFile == nil {
+				if absInPath, err := filepath.Abs(inPath); err == nil {
+					manifest.Add(absInPath, outPath)
+				}
+			}
 		}
 	}
 
@@ -27,6 +27,17 @@ var DocumentContextKey = contextKey{"documentpath"}
 var ParamsContextKey = contextKey{"paramspath"}
 var StringParamsContextKey = contextKey{"strparamspath"}
 var FormatterContextKey = contextKey{"formatterpath"}
+var ConfigContextKey = contextKey{"config"}
+var ManifestContextKey = contextKey{"manifest"}
+var DiagnosticsContextKey = contextKey{"diagnostics"}
+var StateContextKey = contextKey{"state"}
+var MetadataContextKey = contextKey{"metadata"}
+var PlanContextKey = contextKey{"plan"}
+var SerializerOptionsContextKey = contextKey{"serializeroptions"}
+var FormatterLookupContextKey = contextKey{"formatterlookup"}
+var DependenciesContextKey = contextKey{"dependencies"}
+var PluginsContextKey = contextKey{"plugins"}
+var LocalizationContextKey = contextKey{"localization"}
 
 func NewBuildContext() context.Context {
 	logger := log.New(os.Stderr, "🐙 ", 0)
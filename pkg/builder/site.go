@@ -0,0 +1,216 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/transformer"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Site is a build.yaml file. The file is either a list of sections or a
+// mapping with site level settings, a list of sections and an after pipeline
+// that runs once all sections are built.
+type Site struct {
+	transformer.Config `yaml:",inline"`
+	Sections           []BuildSection `yaml:"sections"`
+	After              Pipeline       `yaml:"after"`
+}
+
+func (s *Site) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&s.Sections)
+	}
+	type site Site
+	return value.Decode((*site)(s))
+}
+
+func (s *Site) Build(ctx context.Context, rootPath string) (err error) {
+	rootPathAbsolute, err := filepath.Abs(rootPath)
+	if err != nil {
+		return err
+	}
+
+	if s.Output == "" {
+		s.Output = s.outputDir(rootPath)
+	}
+
+	logger, _ := ctx.Value(builder_context.LoggerContextKey).(*log.Logger)
+	for name := range s.Plugins {
+		if transformer.Registry.Lookup(name) != nil {
+			return errors.New(fmt.Sprintf("plugin has the name of a transformation: %s", name))
+		}
+	}
+	plugins := transformer.NewPlugins(s.Plugins, rootPathAbsolute, logger)
+	defer func() {
+		if closeErr := plugins.Close(); closeErr != nil {
+			if err != nil {
+				err = errors.New(err.Error() + "\n" + closeErr.Error())
+			} else {
+				err = closeErr
+			}
+		}
+	}()
+
+	manifest := transformer.NewManifest()
+	diagnostics := transformer.NewDiagnostics(logger)
+	ctx = context.WithValue(ctx, builder_context.ConfigContextKey, &s.Config)
+	ctx = context.WithValue(ctx, builder_context.ManifestContextKey, manifest)
+	ctx = context.WithValue(ctx, builder_context.DiagnosticsContextKey, diagnostics)
+	state := transformer.NewBuildState()
+	defer state.Close()
+	ctx = context.WithValue(ctx, builder_context.StateContextKey, state)
+	ctx = context.WithValue(ctx, builder_context.FormatterLookupContextKey, lookupFormatter)
+	ctx = context.WithValue(ctx, builder_context.PluginsContextKey, plugins)
+	defer logDiagnosticsSummary(logger, diagnostics)
+
+	if dependencies, ok := ctx.Value(builder_context.DependenciesContextKey).(*transformer.Dependencies); ok {
+		dependencies.Reset()
+	}
+
+	sections, err := s.localizedSections(rootPathAbsolute)
+	if err != nil {
+		return err
+	}
+
+	// pages built for a locale link to the pages of that locale, the other
+	// pages to the pages of the default locale
+	plan := transformer.NewPlan()
+	localePlans := make(map[string]*transformer.Plan)
+	for i := range sections {
+		sectionPlan := plan
+		if localization := sections[i].localization; localization != nil {
+			if localePlans[localization.Locale] == nil {
+				localePlans[localization.Locale] = transformer.NewLocalePlan(plan)
+			}
+			sectionPlan = localePlans[localization.Locale]
+			if localization.Locale == s.I18n.DefaultLocale() {
+				if err := sections[i].Plan(plan, rootPath); err != nil {
+					return err
+				}
+			}
+		}
+		if err := sections[i].Plan(sectionPlan, rootPath); err != nil {
+			return err
+		}
+	}
+	ctx = context.WithValue(ctx, builder_context.PlanContextKey, plan)
+
+	errs := []error{}
+	for i := range sections {
+		sectionCtx := ctx
+		if localization := sections[i].localization; localization != nil {
+			sectionCtx = context.WithValue(ctx, builder_context.PlanContextKey, localePlans[localization.Locale])
+		}
+		if err := sections[i].Build(sectionCtx, rootPath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		return errors.New(strings.Join(messages, "\n"))
+	}
+
+	if len(s.After) > 0 {
+		ctx = context.WithValue(ctx, builder_context.RootPathContextKey, rootPathAbsolute)
+		if _, err := s.After.Transform(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// localizedSections returns the sections of the site, the sections with
+// locales repeated for each of them. The output of a locale is moved into the
+// directory of its prefix in the output directory.
+func (s *Site) localizedSections(rootPathAbsolute string) ([]BuildSection, error) {
+	sections := []BuildSection{}
+	for _, section := range s.Sections {
+		if len(section.Locales) == 0 {
+			sections = append(sections, section)
+			continue
+		}
+		if section.In == "-" || section.Out == "-" {
+			return nil, errors.New("cannot build piped sections per locale")
+		}
+		separator := string(os.PathSeparator)
+		outPath, err := filepath.Rel(filepath.Join(separator, s.Output), filepath.Join(separator, section.Out))
+		if err != nil || outPath == ".." || strings.HasPrefix(outPath, ".."+separator) {
+			return nil, errors.New(fmt.Sprintf("output of section with locales outside the output directory: %s", section.Out))
+		}
+		outPathIsDir := strings.HasSuffix(section.Out, separator)
+		if info, err := os.Stat(filepath.Join(rootPathAbsolute, section.Out)); err == nil && info.IsDir() {
+			outPathIsDir = true
+		}
+
+		outputs := make(map[string]string)
+		for _, name := range section.Locales {
+			locale, ok := s.I18n.Locale(name)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("unknown locale: %s", name))
+			}
+			outputs[name] = filepath.Join(rootPathAbsolute, s.Output, locale.Prefix)
+		}
+		for _, name := range section.Locales {
+			localized := section
+			locale, _ := s.I18n.Locale(name)
+			localized.Out = filepath.Join(separator, s.Output, locale.Prefix, outPath)
+			if outPathIsDir {
+				localized.Out += separator
+			}
+			localized.localization = &transformer.Localization{
+				Locale:  name,
+				Locales: section.Locales,
+				Outputs: outputs,
+			}
+			sections = append(sections, localized)
+		}
+	}
+	return sections, nil
+}
+
+// outputDir is the deepest directory containing the output of every section.
+func (s *Site) outputDir(rootPath string) string {
+	common := ""
+	for i := range s.Sections {
+		out := s.Sections[i].Out
+		if out == "-" || out == "" {
+			continue
+		}
+		dir := path.Dir(filepath.ToSlash(out))
+		if strings.HasSuffix(out, string(os.PathSeparator)) {
+			dir = path.Clean(filepath.ToSlash(out))
+		} else if info, err := os.Stat(filepath.Join(rootPath, out)); err == nil && info.IsDir() {
+			dir = path.Clean(filepath.ToSlash(out))
+		}
+		if common == "" {
+			common = dir
+			continue
+		}
+		for common != dir && common != "/" && common != "." && !strings.HasPrefix(dir, common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	return filepath.FromSlash(common)
+}
+
+func logDiagnosticsSummary(logger *log.Logger, diagnostics *transformer.Diagnostics) {
+	errorCount := diagnostics.Count(transformer.SeverityError)
+	warningCount := diagnostics.Count(transformer.SeverityWarning)
+	if logger == nil || errorCount+warningCount == 0 {
+		return
+	}
+	logger.Printf("%d error(s), %d warning(s)\n", errorCount, warningCount)
+}
@@ -4,7 +4,9 @@ import (
 	"gostatic/pkg/markup"
 
 	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	frontmatter "go.abhg.dev/goldmark/frontmatter"
 )
 
 type Converter struct {
@@ -21,15 +23,25 @@ func New(options ...goldmark.Option) *Converter {
 	return &Converter{goldmark.New(append(options, rendererOptions())...)}
 }
 
-func (c *Converter) Convert(fileSource []byte, doc *markup.Document, node *markup.Node) error {
+// Convert renders fileSource into node and returns the frontmatter of the
+// source, which is nil when there is none.
+func (c *Converter) Convert(fileSource []byte, doc *markup.Document, node *markup.Node) (map[string]interface{}, error) {
 	writer := NewTreeWriter(doc, node)
 	defer writer.Free()
 
-	if err := c.goldmark.Convert(fileSource, &writer); err != nil {
-		return err
+	parserContext := parser.NewContext()
+	if err := c.goldmark.Convert(fileSource, &writer, parser.WithContext(parserContext)); err != nil {
+		return nil, err
 	}
 
 	writer.Terminate()
 
-	return nil
+	var meta map[string]interface{}
+	if data := frontmatter.Get(parserContext); data != nil {
+		if err := data.Decode(&meta); err != nil {
+			return nil, err
+		}
+	}
+
+	return meta, nil
 }
@@ -68,6 +68,14 @@ func (p *HTML5Parser) MyDoc() *Document {
 }
 
 func ReadHTMLFile(srcPath string, options ParserOption) *Document {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return nil
+	}
+	return ReadHTMLMemory(data, options)
+}
+
+func ReadHTMLMemory(data []byte, options ParserOption) *Document {
 	parser := CreateHTML5Parser(
 		NewDoc("1.0"),
 		nil,
@@ -77,13 +85,7 @@ func ReadHTMLFile(srcPath string, options ParserOption) *Document {
 	}
 	defer parser.Free()
 
-	var chunk string
-	if data, err := os.ReadFile(srcPath); err != nil {
-		return nil
-	} else {
-		chunk = string(data)
-	}
-	if res := parser.ParseChunk(chunk); res < 0 {
+	if res := parser.ParseChunk(string(data)); res < 0 {
 		return nil
 	}
 	if res := parser.Terminate(); res < 0 {
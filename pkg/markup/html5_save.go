@@ -14,10 +14,12 @@ import (
 var (
 	voidElements     map[string]bool
 	preElements      map[string]bool
-	literalElements  map[string]bool
 	scriptElements   map[string]bool
 	quoteCharacters  *strings.Replacer
 	escapeCharacters *strings.Replacer
+	// elements whose end tag may be left out when followed by one of these
+	// siblings, or by nothing when the list contains ""
+	optionalEndTags map[string][]string
 )
 
 func hasParent(node *Node, elements map[string]bool) bool {
@@ -28,8 +30,17 @@ func hasParent(node *Node, elements map[string]bool) bool {
 	return false
 }
 
+// HTML5SerializerOptions controls how compact the serialized HTML is.
+// OmitEndTags leaves out end tags an HTML parser adds back by itself and
+// OmitAttributeQuotes the quotes around values that do not need them.
+type HTML5SerializerOptions struct {
+	OmitEndTags         bool
+	OmitAttributeQuotes bool
+}
+
 type HTML5Serializer struct {
-	writer *bufio.Writer
+	writer  *bufio.Writer
+	options HTML5SerializerOptions
 }
 
 func (s *HTML5Serializer) Serialize(doc *Document) error {
@@ -41,8 +52,21 @@ func (s *HTML5Serializer) Serialize(doc *Document) error {
 	return nil
 }
 
+// SerializeChildren writes the children of node, e.g. the content of an
+// element without the element itself.
+func (s *HTML5Serializer) SerializeChildren(node *Node) error {
+	if err := s.serializeFragment(node.Children()); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
 func NewHTML5Serializer(writer *bufio.Writer) *HTML5Serializer {
-	return &HTML5Serializer{writer}
+	return &HTML5Serializer{writer, HTML5SerializerOptions{}}
+}
+
+func NewHTML5SerializerWithOptions(writer *bufio.Writer, options HTML5SerializerOptions) *HTML5Serializer {
+	return &HTML5Serializer{writer, options}
 }
 
 func (s *HTML5Serializer) Write(data []byte) (int, error) {
@@ -98,12 +122,18 @@ func (s *HTML5Serializer) serializeElement(node *Node) error {
 	if _, ok := voidElements[name]; ok {
 		return nil
 	}
-	if _, ok := literalElements[name]; ok {
-		s.Write([]byte{'\n'})
+	// the parser drops a newline at the start of these elements
+	if _, ok := preElements[name]; ok {
+		if child := node.Children(); child != nil && child.Type() == XML_TEXT_NODE && strings.HasPrefix(child.GetContent(), "\n") {
+			s.Write([]byte{'\n'})
+		}
 	}
 	if err := s.serializeFragment(node.Children()); err != nil {
 		return err
 	}
+	if s.options.OmitEndTags && endTagOptional(node) {
+		return nil
+	}
 	if _, err := s.Write([]byte{'<', '/'}); err != nil {
 		return err
 	}
@@ -116,6 +146,36 @@ func (s *HTML5Serializer) serializeElement(node *Node) error {
 	return nil
 }
 
+func endTagOptional(node *Node) bool {
+	followers, ok := optionalEndTags[node.Name()]
+	if !ok {
+		return false
+	}
+	next := node.Next()
+	if node.Name() == "p" && next == nil {
+		// content of these parents, and of autonomous custom elements, may
+		// continue after the paragraph
+		if parent := node.Parent(); parent != nil {
+			switch parent.Name() {
+			case "a", "audio", "del", "ins", "map", "noscript", "video":
+				return false
+			}
+			if strings.Contains(parent.Name(), "-") {
+				return false
+			}
+		}
+	}
+	for _, follower := range followers {
+		if follower == "" && next == nil {
+			return true
+		}
+		if next != nil && next.Type() == XML_ELEMENT_NODE && next.Name() == follower {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *HTML5Serializer) serializeText(node *Node) error {
 	content := node.GetContent()
 	if hasParent(node, scriptElements) {
@@ -195,7 +255,14 @@ func (s *HTML5Serializer) serializeAttribute(attr *Attribute) error {
 	if _, err := s.WriteString(name); err != nil {
 		return err
 	}
-	if content.Len() > 0 {
+	if content.Len() > 0 && s.options.OmitAttributeQuotes && !strings.ContainsAny(content.String(), " \t\n\f\r\"'=<>`") {
+		if _, err := s.Write([]byte{'='}); err != nil {
+			return err
+		}
+		if _, err := s.WriteString(quoteCharacters.Replace(content.String())); err != nil {
+			return err
+		}
+	} else if content.Len() > 0 {
 		if _, err := s.Write([]byte{'=', '"'}); err != nil {
 			return err
 		}
@@ -220,16 +287,32 @@ func init() {
 		preElements[name] = true
 	}
 
-	literalElements = make(map[string]bool)
-	for _, name := range strings.Split("style,script,xmp,iframe,noembed,noframes,noscript,plaintext", ",") {
-		literalElements[name] = true
-	}
-
 	scriptElements = make(map[string]bool)
 	for _, name := range strings.Split("style,script,xmp,iframe,noembed,noframes,noscript,plaintext", ",") {
 		scriptElements[name] = true
 	}
 
-	escapeCharacters = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", "\xa0", "&nbsp;")
-	quoteCharacters = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", "\xa0", "&nbsp;", "\"", "&quote")
+	blockFollowers := strings.Split("address,article,aside,blockquote,details,dialog,div,dl,fieldset,figcaption,figure,footer,form,h1,h2,h3,h4,h5,h6,header,hgroup,hr,main,menu,nav,ol,p,pre,search,section,table,ul,", ",")
+	optionalEndTags = map[string][]string{
+		"html":     {""},
+		"head":     {"body"},
+		"body":     {""},
+		"li":       {"li", ""},
+		"dt":       {"dt", "dd"},
+		"dd":       {"dd", "dt", ""},
+		"p":        blockFollowers,
+		"rt":       {"rt", "rp", ""},
+		"rp":       {"rt", "rp", ""},
+		"optgroup": {"optgroup", ""},
+		"option":   {"option", "optgroup", ""},
+		"thead":    {"tbody", "tfoot"},
+		"tbody":    {"tbody", "tfoot", ""},
+		"tfoot":    {""},
+		"tr":       {"tr", ""},
+		"td":       {"td", "th", ""},
+		"th":       {"td", "th", ""},
+	}
+
+	escapeCharacters = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", "\u00a0", "&nbsp;")
+	quoteCharacters = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", "\u00a0", "&nbsp;", "\"", "&quot;")
 }
@@ -2,6 +2,7 @@
 package markup
 
 /*
+#include <libxml/tree.h>
 #include <libxml/xmlerror.h>
 #include <libxslt/xsltutils.h>
 #include "xml_error.h"
@@ -777,6 +778,31 @@ func (e *Error) String() string {
 	return C.GoString(e.Ptr.message)
 }
 
+func (e *Error) Level() ErrorLevel {
+	return ErrorLevel(e.Ptr.level)
+}
+
+func (e *Error) Code() ParserError {
+	return ParserError(e.Ptr.code)
+}
+
+func (e *Error) File() string {
+	return C.GoString(e.Ptr.file)
+}
+
+func (e *Error) Line() int {
+	return int(e.Ptr.line)
+}
+
+func (e *Error) Column() int {
+	return int(e.Ptr.int2)
+}
+
+// Node returns the node the error is about, valid as long as its document.
+func (e *Error) Node() *Node {
+	return makeNode(C.xmlNodePtr(e.Ptr.node))
+}
+
 // xmlGetLastError
 func GetLastError() *Error {
 	if ptr := C.xmlGetLastError(); ptr != nil {
@@ -271,6 +271,12 @@ func (node *Node) Copy(extended int) *Node {
 	return makeNode(cnode)
 }
 
+// xmlDocCopyNode
+func (doc *Document) CopyNode(node *Node, extended int) *Node {
+	cnode := C.xmlDocCopyNode(node.Ptr, doc.Ptr, C.int(extended))
+	return makeNode(cnode)
+}
+
 // xmlCopyNodeList
 func (node *Node) CopyList() *Node {
 	cnode := C.xmlCopyNodeList(node.Ptr)
@@ -651,6 +657,15 @@ func SetCompressionLevel(level int) {
 	C.xmlSetCompressMode(C.int(level))
 }
 
+// xmlSetNs
+func (node *Node) SetNs(ns *Namespace) {
+	if ns == nil {
+		C.xmlSetNs(node.Ptr, nil)
+		return
+	}
+	C.xmlSetNs(node.Ptr, ns.Ptr)
+}
+
 // xmlSetDocCompressMode
 func (doc *Document) SetCompressionLevel(level int) {
 	C.xmlSetDocCompressMode(doc.Ptr, C.int(level))
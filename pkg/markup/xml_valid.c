@@ -0,0 +1,92 @@
+#include "xml_valid.h"
+
+extern void go_validation_error_callback(void *, xmlErrorPtr);
+
+void
+validation_error_func(void *userData, xmlErrorPtr error) {
+    go_validation_error_callback(userData, error);
+}
+
+/*
+ * DTD parsing and validation report through the global structured error
+ * handler, which is swapped for the duration of the call.
+ */
+xmlDtdPtr
+parse_dtd_file(const xmlChar *systemID, void *userData) {
+    xmlStructuredErrorFunc handler = xmlStructuredError;
+    void *context = xmlStructuredErrorContext;
+    xmlDtdPtr dtd;
+
+    xmlSetStructuredErrorFunc(userData, (xmlStructuredErrorFunc) validation_error_func);
+    dtd = xmlParseDTD(NULL, systemID);
+    xmlSetStructuredErrorFunc(context, handler);
+    return dtd;
+}
+
+int
+validate_dtd(xmlDocPtr doc, xmlDtdPtr dtd, void *userData) {
+    xmlStructuredErrorFunc handler = xmlStructuredError;
+    void *context = xmlStructuredErrorContext;
+    xmlValidCtxtPtr ctxt;
+    int res;
+
+    if ((ctxt = xmlNewValidCtxt()) == NULL)
+        return -1;
+    xmlSetStructuredErrorFunc(userData, (xmlStructuredErrorFunc) validation_error_func);
+    res = xmlValidateDtd(ctxt, doc, dtd);
+    xmlSetStructuredErrorFunc(context, handler);
+    xmlFreeValidCtxt(ctxt);
+    return res;
+}
+
+xmlRelaxNGPtr
+parse_relaxng_file(const char *filename, void *userData) {
+    xmlRelaxNGParserCtxtPtr ctxt;
+    xmlRelaxNGPtr schema;
+
+    if ((ctxt = xmlRelaxNGNewParserCtxt(filename)) == NULL)
+        return NULL;
+    xmlRelaxNGSetParserStructuredErrors(ctxt, (xmlStructuredErrorFunc) validation_error_func, userData);
+    schema = xmlRelaxNGParse(ctxt);
+    xmlRelaxNGFreeParserCtxt(ctxt);
+    return schema;
+}
+
+int
+validate_relaxng(xmlDocPtr doc, xmlRelaxNGPtr schema, void *userData) {
+    xmlRelaxNGValidCtxtPtr ctxt;
+    int res;
+
+    if ((ctxt = xmlRelaxNGNewValidCtxt(schema)) == NULL)
+        return -1;
+    xmlRelaxNGSetValidStructuredErrors(ctxt, (xmlStructuredErrorFunc) validation_error_func, userData);
+    res = xmlRelaxNGValidateDoc(ctxt, doc);
+    xmlRelaxNGFreeValidCtxt(ctxt);
+    return res;
+}
+
+xmlSchemaPtr
+parse_schema_file(const char *filename, void *userData) {
+    xmlSchemaParserCtxtPtr ctxt;
+    xmlSchemaPtr schema;
+
+    if ((ctxt = xmlSchemaNewParserCtxt(filename)) == NULL)
+        return NULL;
+    xmlSchemaSetParserStructuredErrors(ctxt, (xmlStructuredErrorFunc) validation_error_func, userData);
+    schema = xmlSchemaParse(ctxt);
+    xmlSchemaFreeParserCtxt(ctxt);
+    return schema;
+}
+
+int
+validate_schema(xmlDocPtr doc, xmlSchemaPtr schema, void *userData) {
+    xmlSchemaValidCtxtPtr ctxt;
+    int res;
+
+    if ((ctxt = xmlSchemaNewValidCtxt(schema)) == NULL)
+        return -1;
+    xmlSchemaSetValidStructuredErrors(ctxt, (xmlStructuredErrorFunc) validation_error_func, userData);
+    res = xmlSchemaValidateDoc(ctxt, doc);
+    xmlSchemaFreeValidCtxt(ctxt);
+    return res;
+}
@@ -0,0 +1,112 @@
+package markup
+
+/*
+#include <stdlib.h>
+#include "xml_valid.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+type RelaxNG struct {
+	Ptr C.xmlRelaxNGPtr
+}
+
+type Schema struct {
+	Ptr C.xmlSchemaPtr
+}
+
+//export go_validation_error_callback
+func go_validation_error_callback(userData unsafe.Pointer, err C.xmlErrorPtr) {
+	handle := *(*cgo.Handle)(userData)
+	errors := handle.Value().(*[]*Error)
+	var copy C.xmlError
+	C.xmlCopyError(err, &copy)
+	*errors = append(*errors, &Error{&copy})
+}
+
+// collectErrors calls a parser or validator with user data that makes it
+// report to a list of errors instead of the error logger.
+func collectErrors(call func(userData unsafe.Pointer)) []*Error {
+	errors := []*Error{}
+	handle := cgo.NewHandle(&errors)
+	defer handle.Delete()
+	call(unsafe.Pointer(&handle))
+	return errors
+}
+
+// xmlParseDTD
+func ParseDTDFile(filename string) (*Dtd, []*Error) {
+	ptrf := C.CString(filename)
+	defer C.free(unsafe.Pointer(ptrf))
+	var dtd *Dtd
+	errors := collectErrors(func(userData unsafe.Pointer) {
+		dtd = makeDtd(C.parse_dtd_file((*C.xmlChar)(unsafe.Pointer(ptrf)), userData))
+	})
+	return dtd, errors
+}
+
+// xmlValidateDtd
+func (dtd *Dtd) Validate(doc *Document) (bool, []*Error) {
+	var res C.int
+	errors := collectErrors(func(userData unsafe.Pointer) {
+		res = C.validate_dtd(doc.Ptr, dtd.Ptr, userData)
+	})
+	return res == 1, errors
+}
+
+// xmlRelaxNGParse
+func ParseRelaxNGFile(filename string) (*RelaxNG, []*Error) {
+	ptrf := C.CString(filename)
+	defer C.free(unsafe.Pointer(ptrf))
+	var schema *RelaxNG
+	errors := collectErrors(func(userData unsafe.Pointer) {
+		if ptr := C.parse_relaxng_file(ptrf, userData); ptr != nil {
+			schema = &RelaxNG{ptr}
+		}
+	})
+	return schema, errors
+}
+
+// xmlRelaxNGValidateDoc
+func (schema *RelaxNG) Validate(doc *Document) (bool, []*Error) {
+	var res C.int
+	errors := collectErrors(func(userData unsafe.Pointer) {
+		res = C.validate_relaxng(doc.Ptr, schema.Ptr, userData)
+	})
+	return res == 0, errors
+}
+
+// xmlRelaxNGFree
+func (schema *RelaxNG) Free() {
+	C.xmlRelaxNGFree(schema.Ptr)
+}
+
+// xmlSchemaParse
+func ParseSchemaFile(filename string) (*Schema, []*Error) {
+	ptrf := C.CString(filename)
+	defer C.free(unsafe.Pointer(ptrf))
+	var schema *Schema
+	errors := collectErrors(func(userData unsafe.Pointer) {
+		if ptr := C.parse_schema_file(ptrf, userData); ptr != nil {
+			schema = &Schema{ptr}
+		}
+	})
+	return schema, errors
+}
+
+// xmlSchemaValidateDoc
+func (schema *Schema) Validate(doc *Document) (bool, []*Error) {
+	var res C.int
+	errors := collectErrors(func(userData unsafe.Pointer) {
+		res = C.validate_schema(doc.Ptr, schema.Ptr, userData)
+	})
+	return res == 0, errors
+}
+
+// xmlSchemaFree
+func (schema *Schema) Free() {
+	C.xmlSchemaFree(schema.Ptr)
+}
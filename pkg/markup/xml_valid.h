@@ -0,0 +1,15 @@
+#include <libxml/tree.h>
+#include <libxml/valid.h>
+#include <libxml/relaxng.h>
+#include <libxml/xmlschemas.h>
+
+void validation_error_func(void *, xmlErrorPtr);
+
+xmlDtdPtr parse_dtd_file(const xmlChar *systemID, void *userData);
+int validate_dtd(xmlDocPtr doc, xmlDtdPtr dtd, void *userData);
+
+xmlRelaxNGPtr parse_relaxng_file(const char *filename, void *userData);
+int validate_relaxng(xmlDocPtr doc, xmlRelaxNGPtr schema, void *userData);
+
+xmlSchemaPtr parse_schema_file(const char *filename, void *userData);
+int validate_schema(xmlDocPtr doc, xmlSchemaPtr schema, void *userData);
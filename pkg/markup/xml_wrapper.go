@@ -44,6 +44,15 @@ func (node *Node) Next() *Node {
 	return makeNode(C.xmlNodePtr(unsafe.Pointer(node.Ptr.next)))
 }
 
+func (node *Node) Prev() *Node {
+	return makeNode(C.xmlNodePtr(unsafe.Pointer(node.Ptr.prev)))
+}
+
+// xmlGetLineNo
+func (node *Node) Line() int {
+	return int(C.xmlGetLineNo(node.Ptr))
+}
+
 func (node *Node) Attributes() *Attribute {
 	return makeAttribute(C.xmlAttrPtr(unsafe.Pointer(node.Ptr.properties)))
 }
@@ -121,7 +121,7 @@ func (obj *XPathObject) ConvertString() *XPathObject {
 func (obj *XPathObject) ResultsChannel() chan *Node {
 	channel := make(chan *Node)
 	go func(obj *XPathObject, channel chan *Node) {
-		if obj.Ptr._type != 1 {
+		if obj.Ptr._type != 1 || obj.Ptr.nodesetval == nil {
 			close(channel)
 			return
 		}
@@ -138,7 +138,7 @@ func (obj *XPathObject) Results() []*Node {
 		results []*Node
 		length  int
 	)
-	if obj.Ptr._type != 1 {
+	if obj.Ptr._type != 1 || obj.Ptr.nodesetval == nil {
 		return []*Node{}
 	}
 	length = int(obj.Ptr.nodesetval.nodeNr)
@@ -163,6 +163,15 @@ func (ctx *XPathContext) Eval(str string) *XPathObject {
 	return makeXpathObj(C.xmlXPathEval((*C.xmlChar)(unsafe.Pointer(ptr)), ctx.Ptr))
 }
 
+// xmlXPathContext.node
+func (ctx *XPathContext) SetNode(node *Node) {
+	if node == nil {
+		ctx.Ptr.node = nil
+		return
+	}
+	ctx.Ptr.node = node.Ptr
+}
+
 // xmlXPathEvalPredicate
 func (ctx *XPathContext) EvalPredicate(res *XPathObject) bool {
 	result := C.xmlXPathEvalPredicate(ctx.Ptr, res.Ptr)
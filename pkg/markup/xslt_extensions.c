@@ -2,9 +2,11 @@
 #include "xslt_extensions.h"
 
 extern void FormatDateCallback(xmlXPathParserContextPtr ctx, int nargs);
+extern void TranslateCallback(xmlXPathParserContextPtr ctx, int nargs);
 
 void registerExtensionFunctions(xsltTransformContextPtr ctx) {
 	xsltRegisterExtFunction(ctx, BAD_CAST "format-date", (xmlChar *)GOSTATIC_NAMESPACE, &FormatDateCallback);
+	xsltRegisterExtFunction(ctx, BAD_CAST "translate", (xmlChar *)GOSTATIC_NAMESPACE, &TranslateCallback);
 }
 
 
@@ -1,6 +1,7 @@
 package markup
 
 /*
+#include <stdint.h>
 #include <stdlib.h>
 #include <libxml/parser.h>
 #include <libxml/xpath.h>
@@ -11,10 +12,16 @@ package markup
 */
 import "C"
 import (
+	"runtime/cgo"
 	"time"
 	"unsafe"
 )
 
+// Translator looks up the messages of the translate extension function.
+type Translator interface {
+	Translate(key string) (string, bool)
+}
+
 //export FormatDateCallback
 func FormatDateCallback(ctx C.xmlXPathParserContextPtr, nArgs C.int) {
 	var (
@@ -44,6 +51,46 @@ func FormatDateCallback(ctx C.xmlXPathParserContextPtr, nArgs C.int) {
 	C.valuePush(ctx, C.xmlXPathWrapString((*C.xmlChar)(unsafe.Pointer(result))))
 }
 
+// TranslateCallback returns the message of a key from the translator of the
+// transform context, or the optional second argument or the key when there
+// is none.
+//
+//export TranslateCallback
+func TranslateCallback(ctx C.xmlXPathParserContextPtr, nArgs C.int) {
+	if nArgs < 1 || nArgs > 2 {
+		filename := C.CString("missing __FILE__ macro")
+		defer C.free(unsafe.Pointer(filename))
+		C.xmlXPatherror(ctx, filename, 0, C.XPATH_INVALID_ARITY)
+		if ctx != nil {
+			ctx.error = C.XPATH_INVALID_ARITY
+		}
+		return
+	}
+	fallback := ""
+	if nArgs == 2 {
+		arg2 := C.xmlXPathPopString(ctx)
+		fallback = C.GoString((*C.char)(unsafe.Pointer(arg2)))
+		C.free(unsafe.Pointer(arg2))
+	}
+	arg1 := C.xmlXPathPopString(ctx)
+	key := C.GoString((*C.char)(unsafe.Pointer(arg1)))
+	C.free(unsafe.Pointer(arg1))
+
+	message := key
+	if nArgs == 2 {
+		message = fallback
+	}
+	if tctxt := C.xsltXPathGetTransformContext(ctx); tctxt != nil && tctxt._private != nil {
+		handle := cgo.Handle(*(*C.uintptr_t)(tctxt._private))
+		if translated, ok := handle.Value().(Translator).Translate(key); ok {
+			message = translated
+		}
+	}
+	result := C.CString(message)
+
+	C.valuePush(ctx, C.xmlXPathWrapString((*C.xmlChar)(unsafe.Pointer(result))))
+}
+
 func RegisterDynamicNamespace() {
 	C.exsltDynRegister()
 }
@@ -1,6 +1,8 @@
 package markup
 
 /*
+#include <stdint.h>
+#include <stdlib.h>
 #include <libxml/tree.h>
 #include <libxml/parser.h>
 #include <libxslt/transform.h>
@@ -26,8 +28,9 @@ const (
 )
 
 type TransformContext struct {
-	Ptr    C.xsltTransformContextPtr
-	Logger *log.Logger
+	Ptr        C.xsltTransformContextPtr
+	Logger     *log.Logger
+	translator *cgo.Handle
 }
 
 func NewTransformContext(style *Stylesheet, doc *Document, logger *log.Logger) *TransformContext {
@@ -36,7 +39,7 @@ func NewTransformContext(style *Stylesheet, doc *Document, logger *log.Logger) *
 		C.xsltSetCtxtParseOptions(ptr, XSLT_PARSE_OPTIONS)
 		handle := cgo.NewHandle(logger)
 		C.set_xslt_transform_error_func(ptr, unsafe.Pointer(&handle))
-		return &TransformContext{ptr, logger}
+		return &TransformContext{Ptr: ptr, Logger: logger}
 	}
 	return nil
 }
@@ -65,7 +68,24 @@ func (t *TransformContext) ApplyStylesheet(style *Stylesheet, doc *Document, par
 	return nil
 }
 
+// SetTranslator sets the translator of the translate extension function.
+func (t *TransformContext) SetTranslator(translator Translator) {
+	if t.translator != nil {
+		t.translator.Delete()
+	} else {
+		t.Ptr._private = C.malloc(C.sizeof_uintptr_t)
+	}
+	handle := cgo.NewHandle(translator)
+	t.translator = &handle
+	*(*C.uintptr_t)(t.Ptr._private) = C.uintptr_t(handle)
+}
+
 func (t *TransformContext) Free() {
+	if t.translator != nil {
+		t.translator.Delete()
+		C.free(t.Ptr._private)
+		t.Ptr._private = nil
+	}
 	C.xsltFreeTransformContext(t.Ptr)
 }
 
@@ -2,6 +2,7 @@ package transformer
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	builder_context "gostatic/pkg/builder/context"
@@ -18,29 +19,636 @@ var (
 	jsLoaders  map[string]api.Loader
 )
 
-func buildBundle(buildOptions api.BuildOptions) string {
+var bundleEngines = map[string]api.EngineName{
+	"chrome":  api.EngineChrome,
+	"deno":    api.EngineDeno,
+	"edge":    api.EngineEdge,
+	"firefox": api.EngineFirefox,
+	"hermes":  api.EngineHermes,
+	"ie":      api.EngineIE,
+	"ios":     api.EngineIOS,
+	"node":    api.EngineNode,
+	"opera":   api.EngineOpera,
+	"rhino":   api.EngineRhino,
+	"safari":  api.EngineSafari,
+}
+
+var bundleLoaders = map[string]api.Loader{
+	"js":      api.LoaderJS,
+	"jsx":     api.LoaderJSX,
+	"ts":      api.LoaderTS,
+	"tsx":     api.LoaderTSX,
+	"json":    api.LoaderJSON,
+	"text":    api.LoaderText,
+	"base64":  api.LoaderBase64,
+	"dataurl": api.LoaderDataURL,
+	"file":    api.LoaderFile,
+	"binary":  api.LoaderBinary,
+	"css":     api.LoaderCSS,
+	"copy":    api.LoaderCopy,
+	"empty":   api.LoaderEmpty,
+}
+
+var bundleTargets = map[string]api.Target{
+	"esnext": api.ESNext,
+	"es5":    api.ES5,
+	"es2015": api.ES2015,
+	"es2016": api.ES2016,
+	"es2017": api.ES2017,
+	"es2018": api.ES2018,
+	"es2019": api.ES2019,
+	"es2020": api.ES2020,
+	"es2021": api.ES2021,
+	"es2022": api.ES2022,
+}
+
+// BundleConfig is the esbuild configuration of the bundle transformation.
+// Named profiles override the top level settings and are selected with the
+// first argument of the transformation, e.g. bundle:dev.
+type BundleConfig struct {
+	Target      []string                `yaml:"target"`
+	Minify      *bool                   `yaml:"minify"`
+	Sourcemap   string                  `yaml:"sourcemap"`
+	Define      map[string]string       `yaml:"define"`
+	Inject      []string                `yaml:"inject"`
+	JSXFactory  string                  `yaml:"jsxFactory"`
+	JSXFragment string                  `yaml:"jsxFragment"`
+	External    []string                `yaml:"external"`
+	Format      string                  `yaml:"format"`
+	LogLevel    string                  `yaml:"logLevel"`
+	Emit        string                  `yaml:"emit"`
+	Outdir      string                  `yaml:"outdir"`
+	Hash        *bool                   `yaml:"hash"`
+	Splitting   *bool                   `yaml:"splitting"`
+	Entries     []string                `yaml:"entries"`
+	Fonts       BundleAssetConfig       `yaml:"fonts"`
+	Images      BundleAssetConfig       `yaml:"images"`
+	Loaders     map[string]string       `yaml:"loaders"`
+	Classic     string                  `yaml:"classic"`
+	Resolve     string                  `yaml:"resolve"`
+	Profiles    map[string]BundleConfig `yaml:"profiles"`
+}
+
+func (c BundleConfig) Profile(name string) (BundleConfig, error) {
+	if name == "" {
+		return c, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return c, errors.New(fmt.Sprintf("unknown bundle profile: %s", name))
+	}
+	if len(profile.Target) > 0 {
+		c.Target = profile.Target
+	}
+	if profile.Minify != nil {
+		c.Minify = profile.Minify
+	}
+	if profile.Sourcemap != "" {
+		c.Sourcemap = profile.Sourcemap
+	}
+	if len(profile.Define) > 0 {
+		define := make(map[string]string)
+		for k, v := range c.Define {
+			define[k] = v
+		}
+		for k, v := range profile.Define {
+			define[k] = v
+		}
+		c.Define = define
+	}
+	if len(profile.Inject) > 0 {
+		c.Inject = profile.Inject
+	}
+	if profile.JSXFactory != "" {
+		c.JSXFactory = profile.JSXFactory
+	}
+	if profile.JSXFragment != "" {
+		c.JSXFragment = profile.JSXFragment
+	}
+	if len(profile.External) > 0 {
+		c.External = profile.External
+	}
+	if profile.Format != "" {
+		c.Format = profile.Format
+	}
+	if profile.LogLevel != "" {
+		c.LogLevel = profile.LogLevel
+	}
+	if profile.Emit != "" {
+		c.Emit = profile.Emit
+	}
+	if profile.Outdir != "" {
+		c.Outdir = profile.Outdir
+	}
+	if profile.Hash != nil {
+		c.Hash = profile.Hash
+	}
+	if profile.Splitting != nil {
+		c.Splitting = profile.Splitting
+	}
+	if len(profile.Entries) > 0 {
+		c.Entries = profile.Entries
+	}
+	if len(profile.Loaders) > 0 {
+		loaders := make(map[string]string)
+		for k, v := range c.Loaders {
+			loaders[k] = v
+		}
+		for k, v := range profile.Loaders {
+			loaders[k] = v
+		}
+		c.Loaders = loaders
+	}
+	if profile.Classic != "" {
+		c.Classic = profile.Classic
+	}
+	if profile.Resolve != "" {
+		c.Resolve = profile.Resolve
+	}
+	c.Fonts = c.Fonts.merge(profile.Fonts)
+	c.Images = c.Images.merge(profile.Images)
+	c.Profiles = nil
+	return c, nil
+}
+
+// bundleLoaderMap adds the configured loaders to the loaders of a bundle.
+// Extensions are written with or without the leading dot, e.g. .svg: text.
+func bundleLoaderMap(loaders map[string]api.Loader, config map[string]string) (map[string]api.Loader, error) {
+	loaderMap := make(map[string]api.Loader)
+	for ext, loader := range loaders {
+		loaderMap[ext] = loader
+	}
+	for ext, name := range config {
+		loader, ok := bundleLoaders[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown bundle loader: %s", name))
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		loaderMap[ext] = loader
+	}
+	return loaderMap, nil
+}
+
+// bundleTarget parses esbuild style targets like es2020, chrome58 or safari11.1
+func bundleTarget(targets []string) (api.Target, []api.Engine, error) {
+	target := api.ESNext
+	engines := []api.Engine{}
+	for _, name := range targets {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if t, ok := bundleTargets[name]; ok {
+			target = t
+			continue
+		}
+		i := strings.IndexAny(name, "0123456789")
+		if i <= 0 {
+			return target, engines, errors.New(fmt.Sprintf("unknown bundle target: %s", name))
+		}
+		engine, ok := bundleEngines[name[:i]]
+		if !ok {
+			return target, engines, errors.New(fmt.Sprintf("unknown bundle target: %s", name))
+		}
+		engines = append(engines, api.Engine{Name: engine, Version: name[i:]})
+	}
+	return target, engines, nil
+}
+
+type bundler struct {
+	ctx      context.Context
+	rootPath string
+	inPath   string
+	outPath  string
+	profile  string
+	config   BundleConfig
+	count    int
+}
+
+// bundleOutput is an emitted entry point bundle with the chunks it imports
+// and the stylesheet esbuild extracted from it. Paths are absolute.
+type bundleOutput struct {
+	path    string
+	imports []string
+	css     string
+}
+
+type bundleMetafile struct {
+	Outputs map[string]struct {
+		Imports []struct {
+			Path string `json:"path"`
+			Kind string `json:"kind"`
+		} `json:"imports"`
+		EntryPoint string `json:"entryPoint"`
+		CSSBundle  string `json:"cssBundle"`
+	} `json:"outputs"`
+}
+
+func newBundler(ctx context.Context, rootPath string, args []string) (*bundler, error) {
+	profile := ""
+	if len(args) > 0 {
+		profile = args[0]
+	}
+	config, err := siteConfig(ctx).Bundle.Profile(profile)
+	if err != nil {
+		return nil, err
+	}
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	outPath, ok := ctx.Value(builder_context.OutPathContextKey).(string)
+	if !ok || outPath == "-" {
+		outPath = filepath.Join(rootPath, "stdout")
+	}
+	return &bundler{ctx, rootPath, inPath, outPath, profile, config, 0}, nil
+}
+
+// resolve returns the source file an element attribute refers to.
+func (b *bundler) resolve(ref string) string {
+	return b.resolveIn(b.resolveDir(), ref)
+}
+
+// resolveIn returns the source file a reference refers to, relative
+// references are resolved in dir.
+func (b *bundler) resolveIn(dir string, ref string) string {
+	refPath, _ := splitURL(ref)
+	if strings.HasPrefix(refPath, "/") {
+		return filepath.Join(b.rootPath, filepath.FromSlash(refPath))
+	}
+	return filepath.Join(dir, filepath.FromSlash(refPath))
+}
+
+// resolveDir is the directory relative references of the document are
+// resolved in: the root, or the directory of the input file when resolve is
+// document.
+func (b *bundler) resolveDir() string {
+	if b.config.Resolve == "document" {
+		return filepath.Dir(b.inPath)
+	}
+	return b.rootPath
+}
+
+// assetURL returns the reference to an external asset, its path relative to
+// the root, from the bundle of the file importing it. Emitted bundles are
+// placed in the asset directory like their entry points are in the root, the
+// others inside the output document.
+func (b *bundler) assetURL(emitted bool) func(importer string, relPath string) (string, error) {
+	return func(importer string, relPath string) (string, error) {
+		baseDir := filepath.Dir(b.outPath)
+		if emitted {
+			importerDir, err := filepath.Rel(b.rootPath, filepath.Dir(importer))
+			if err != nil {
+				return "", err
+			}
+			baseDir = filepath.Join(b.assetDir(), importerDir)
+		}
+		url, err := filepath.Rel(baseDir, filepath.Join(outputRoot(b.ctx), relPath))
+		if err != nil {
+			return "", err
+		}
+		return filepath.ToSlash(url), nil
+	}
+}
+
+// assetDir is the output directory of bundles and the files they reference.
+func (b *bundler) assetDir() string {
+	outdir := b.config.Outdir
+	if outdir == "" {
+		outdir = "assets"
+	}
+	return filepath.Join(outputRoot(b.ctx), outdir)
+}
+
+// url returns a reference to an emitted file relative to the output document.
+func (b *bundler) url(path string) string {
+	relPath, err := filepath.Rel(filepath.Dir(b.outPath), path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(relPath)
+}
+
+func (b *bundler) writeOutputFiles(outputFiles []api.OutputFile) error {
+	manifest, _ := b.ctx.Value(builder_context.ManifestContextKey).(*Manifest)
+	for _, outputFile := range outputFiles {
+		if err := os.MkdirAll(filepath.Dir(outputFile.Path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(outputFile.Path, outputFile.Contents, 0644); err != nil {
+			return err
+		}
+		if manifest != nil {
+			manifest.Add("", outputFile.Path)
+		}
+	}
+	return nil
+}
+
+// external bundles entry points into files in the output tree instead of
+// returning their content. Chunks shared by the entry points are split out
+// when splitting is enabled.
+func (b *bundler) external(node *markup.Node, entryPoints []string, loaders map[string]api.Loader) (map[string]bundleOutput, error) {
+	options, err := configBundleOptions(b.rootPath, loaders, b.config, b.assetURL(true))
+	if err != nil {
+		return nil, err
+	}
+	options.Outdir = b.assetDir()
+	options.Outbase = b.rootPath
+	options.Metafile = true
+	options.Splitting = b.config.Splitting != nil && *b.config.Splitting && options.Format == api.FormatESModule
+	options.EntryNames = "[dir]/[name]"
+	if b.config.Hash != nil && *b.config.Hash {
+		options.EntryNames = "[dir]/[name]-[hash]"
+	}
+	options.ChunkNames = "chunks/[name]-[hash]"
+	options.AssetNames = "media/[name]-[hash]"
+	options.EntryPoints = entryPoints
+
+	result := api.Build(options)
+	if err := b.report(node, result); err != nil {
+		return nil, err
+	}
+	if err := b.writeOutputFiles(result.OutputFiles); err != nil {
+		return nil, err
+	}
+
+	var metafile bundleMetafile
+	if err := json.Unmarshal([]byte(result.Metafile), &metafile); err != nil {
+		return nil, err
+	}
+	outputs := make(map[string]bundleOutput)
+	for outPath, output := range metafile.Outputs {
+		if output.EntryPoint == "" {
+			continue
+		}
+		bundle := bundleOutput{path: filepath.Join(b.rootPath, outPath)}
+		for _, imported := range output.Imports {
+			if imported.Kind == "import-statement" {
+				bundle.imports = append(bundle.imports, filepath.Join(b.rootPath, imported.Path))
+			}
+		}
+		if output.CSSBundle != "" {
+			bundle.css = filepath.Join(b.rootPath, output.CSSBundle)
+		}
+		outputs[filepath.Join(b.rootPath, output.EntryPoint)] = bundle
+	}
+	return outputs, nil
+}
+
+// entries bundles the configured site wide entry points once per build so
+// that every page using them shares the same files and chunks.
+func (b *bundler) entries() (map[string]bundleOutput, error) {
+	if len(b.config.Entries) == 0 {
+		return map[string]bundleOutput{}, nil
+	}
+	value, err := buildState(b.ctx).Load("bundle.entries:"+b.profile, func() (interface{}, error) {
+		entryPoints := []string{}
+		for _, pattern := range b.config.Entries {
+			matches, err := filepath.Glob(filepath.Join(b.rootPath, pattern))
+			if err != nil {
+				return nil, err
+			}
+			entryPoints = append(entryPoints, matches...)
+		}
+		return b.external(nil, entryPoints, jsLoaders)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]bundleOutput), nil
+}
+
+// emitExternal replaces module scripts and stylesheet links with references
+// to bundles written to the output tree.
+func (b *bundler) emitExternal(document *markup.Document, xpath *markup.XPathContext) error {
+	var head *markup.Node
+	if headElements := xpath.Eval("/html/head"); headElements != nil {
+		if results := headElements.Results(); len(results) > 0 {
+			head = results[0]
+		}
+		headElements.Free()
+	}
+	preloads := make(map[string]bool)
+	addLink := func(rel string, href string) {
+		if head == nil || preloads[rel+href] {
+			return
+		}
+		preloads[rel+href] = true
+		link := document.NewNode(nil, "link", "")
+		link.SetAttribute("rel", rel)
+		link.SetAttribute("href", href)
+		head.AddChild(link)
+	}
+	removePreloads := func(ref string) {
+		if preloadElements := xpath.Eval(fmt.Sprintf("/html/head/link[@rel='preload' and @href='%s']", ref)); preloadElements != nil {
+			for _, preloadNode := range preloadElements.Results() {
+				preloadNode.Unlink()
+			}
+			preloadElements.Free()
+		}
+	}
+
+	if linkElements := xpath.Eval("//link[@rel='stylesheet' and @href and string-length(@href) != 0]"); linkElements != nil {
+		defer linkElements.Free()
+		nodes := []*markup.Node{}
+		entryPoints := []string{}
+		for _, node := range linkElements.Results() {
+			if href := node.GetAttribute("href"); isLocalURL(href) {
+				nodes = append(nodes, node)
+				entryPoints = append(entryPoints, b.resolve(href))
+			}
+		}
+		if len(nodes) > 0 {
+			outputs, err := b.external(nodes[0], entryPoints, cssLoaders)
+			if err != nil {
+				return err
+			}
+			for i, node := range nodes {
+				if output, ok := outputs[entryPoints[i]]; ok {
+					removePreloads(node.GetAttribute("href"))
+					node.SetAttribute("href", b.url(output.path))
+				}
+			}
+		}
+	}
+
+	if scriptElements := xpath.Eval("//script[@src and string-length(@src) != 0 and @type='module']"); scriptElements != nil {
+		defer scriptElements.Free()
+		shared, err := b.entries()
+		if err != nil {
+			return err
+		}
+		nodes := scriptElements.Results()
+		entryPoints := make([]string, len(nodes))
+		pageEntryPoints := []string{}
+		var pageNode *markup.Node
+		for i, node := range nodes {
+			entryPoints[i] = b.resolve(node.GetAttribute("src"))
+			if _, ok := shared[entryPoints[i]]; !ok {
+				pageEntryPoints = append(pageEntryPoints, entryPoints[i])
+				if pageNode == nil {
+					pageNode = node
+				}
+			}
+		}
+		outputs := make(map[string]bundleOutput)
+		for entryPoint, output := range shared {
+			outputs[entryPoint] = output
+		}
+		if len(pageEntryPoints) > 0 {
+			pageOutputs, err := b.external(pageNode, pageEntryPoints, jsLoaders)
+			if err != nil {
+				return err
+			}
+			for entryPoint, output := range pageOutputs {
+				outputs[entryPoint] = output
+			}
+		}
+		for i, node := range nodes {
+			output, ok := outputs[entryPoints[i]]
+			if !ok {
+				continue
+			}
+			removePreloads(node.GetAttribute("src"))
+			node.SetAttribute("src", b.url(output.path))
+			for _, chunk := range output.imports {
+				addLink("modulepreload", b.url(chunk))
+			}
+			if output.css != "" {
+				addLink("stylesheet", b.url(output.css))
+			}
+		}
+	}
+
+	return nil
+}
+
+// options names the bundle after the output file so that external source maps
+// of several bundles in one document do not overwrite each other. Files the
+// bundle references are referred to relative to the output document because
+// the bundle is placed inside it.
+func (b *bundler) options(loaders map[string]api.Loader, ext string, entryPoints int) (api.BuildOptions, error) {
+	options, err := configBundleOptions(b.rootPath, loaders, b.config, b.assetURL(false))
+	if err != nil {
+		return options, err
+	}
+	b.count++
+	outDir := filepath.Dir(b.outPath)
+	if entryPoints > 1 {
+		options.Outdir = outDir
+	} else {
+		base := strings.TrimSuffix(filepath.Base(b.outPath), filepath.Ext(b.outPath))
+		options.Outfile = filepath.Join(outDir, fmt.Sprintf("%s.%d%s", base, b.count, ext))
+	}
+	options.AssetNames = "media/[name]-[hash]"
+	options.PublicPath = b.url(b.assetDir())
+	return options, nil
+}
+
+// report turns esbuild messages into build diagnostics for the element that
+// was bundled and returns an error when the bundle failed.
+func (b *bundler) report(node *markup.Node, result api.BuildResult) error {
+	element := ""
+	if node != nil {
+		element = node.Path()
+	}
+	report := func(severity Severity, message api.Message) {
+		diagnostic := Diagnostic{
+			Severity: severity,
+			File:     b.inPath,
+			Element:  element,
+			Message:  message.Text,
+		}
+		if location := message.Location; location != nil {
+			diagnostic.File = location.File
+			diagnostic.Line = location.Line
+			diagnostic.Column = location.Column + 1
+			diagnostic.Snippet = location.LineText
+		}
+		for _, note := range message.Notes {
+			diagnostic.Message += "; " + note.Text
+		}
+		ReportDiagnostic(b.ctx, diagnostic)
+	}
+	for _, message := range result.Warnings {
+		report(SeverityWarning, message)
+	}
+	for _, message := range result.Errors {
+		report(SeverityError, message)
+	}
+	if n := len(result.Errors); n > 0 {
+		return errors.New(fmt.Sprintf("%s: bundle failed with %d error(s) in %s", b.inPath, n, element))
+	}
+	return nil
+}
+
+// build returns the bundled code and writes source maps and referenced files.
+// esbuild places referenced files next to the bundle, they are moved to the
+// asset directory the public path points to.
+func (b *bundler) build(node *markup.Node, buildOptions api.BuildOptions, ext string) (string, error) {
 	var builder strings.Builder
 
 	result := api.Build(buildOptions)
+	if err := b.report(node, result); err != nil {
+		return "", err
+	}
+	outDir := buildOptions.Outdir
+	if outDir == "" {
+		outDir = filepath.Dir(buildOptions.Outfile)
+	}
 	for i := range result.OutputFiles {
-		builder.Write(result.OutputFiles[i].Contents)
+		outputFile := result.OutputFiles[i]
+		if strings.HasSuffix(outputFile.Path, ".map") {
+			if err := b.writeOutputFiles([]api.OutputFile{outputFile}); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if filepath.Ext(outputFile.Path) != ext {
+			relPath, err := filepath.Rel(outDir, outputFile.Path)
+			if err != nil {
+				return "", err
+			}
+			outputFile.Path = filepath.Join(b.assetDir(), relPath)
+			if err := b.writeOutputFiles([]api.OutputFile{outputFile}); err != nil {
+				return "", err
+			}
+			continue
+		}
+		builder.Write(outputFile.Contents)
 	}
 
-	return strings.TrimSpace(builder.String())
+	return strings.TrimSpace(builder.String()), nil
 }
 
-func bundleInline(content string, rootPath string, stdinOptions api.StdinOptions, loaders map[string]api.Loader) string {
-	buildOptions := configBundleOptions(rootPath, loaders)
+func (b *bundler) bundleInline(node *markup.Node, stdinOptions api.StdinOptions, loaders map[string]api.Loader, ext string) (string, error) {
+	buildOptions, err := b.options(loaders, ext, 1)
+	if err != nil {
+		return "", err
+	}
 	buildOptions.Stdin = &stdinOptions
 
-	return buildBundle(buildOptions)
+	return b.build(node, buildOptions, ext)
 }
 
-func bundle(filePaths []string, rootPath string, loaders map[string]api.Loader) string {
-	buildOptions := configBundleOptions(rootPath, loaders)
+// bundleClassic bundles the source of a script without a type into a self
+// contained script that can be inlined.
+func (b *bundler) bundleClassic(node *markup.Node, filePath string) (string, error) {
+	buildOptions, err := b.options(jsLoaders, ".js", 1)
+	if err != nil {
+		return "", err
+	}
+	buildOptions.Format = api.FormatIIFE
+	buildOptions.EntryPoints = []string{filePath}
+
+	return b.build(node, buildOptions, ".js")
+}
+
+func (b *bundler) bundle(node *markup.Node, filePaths []string, loaders map[string]api.Loader, ext string) (string, error) {
+	buildOptions, err := b.options(loaders, ext, len(filePaths))
+	if err != nil {
+		return "", err
+	}
 	buildOptions.EntryPoints = filePaths
 
-	return buildBundle(buildOptions)
+	return b.build(node, buildOptions, ext)
 }
 
 func TransformBundle(ctx context.Context, args []string) (context.Context, Status, error) {
@@ -59,6 +667,11 @@ func TransformBundle(ctx context.Context, args []string) (context.Context, Statu
 		return ctx, Continue, errors.New("missing input path")
 	}
 
+	b, err := newBundler(ctx, rootPath, args)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
 	xpath := markup.NewXPathContext(document)
 	defer xpath.Free()
 
@@ -66,92 +679,125 @@ func TransformBundle(ctx context.Context, args []string) (context.Context, Statu
 	for _, node := range styleElements.Results() {
 		stdinOptions := api.StdinOptions{
 			Contents:   node.GetContent(),
-			ResolveDir: rootPath,
+			ResolveDir: b.resolveDir(),
 			Sourcefile: inPath,
 			Loader:     api.LoaderCSS,
 		}
-		result := bundleInline(node.GetContent(), rootPath, stdinOptions, cssLoaders)
+		result, err := b.bundleInline(node, stdinOptions, cssLoaders, ".css")
+		if err != nil {
+			return ctx, Continue, err
+		}
 		node.SetContent(result)
 	}
 
-	var linkElements *markup.XPathObject
+	var scriptElements *markup.XPathObject
 
-	linkElements = xpath.Eval("/html/head/link[@rel='stylesheet' and @href and string-length(@href) != 0]")
-	linkPaths := []string{}
-	var linkNode *markup.Node
-	for _, node := range linkElements.Results() {
-		linkPath := node.GetAttribute("href")
-		linkPaths = append(linkPaths, linkPath)
-		if linkNode != nil {
-			node.Unlink()
-		} else {
-			linkNode = node
+	if b.config.Emit == "external" {
+		if err := b.emitExternal(document, xpath); err != nil {
+			return ctx, Continue, err
 		}
-		preloadLinkElements := xpath.Eval(fmt.Sprintf("/html/head/link[@rel='preload' and @href='%s']", linkPath))
-		for _, preloadNode := range preloadLinkElements.Results() {
-			preloadNode.Unlink()
+	} else {
+		var linkElements *markup.XPathObject
+
+		linkElements = xpath.Eval("/html/head/link[@rel='stylesheet' and @href and string-length(@href) != 0]")
+		linkPaths := []string{}
+		var linkNode *markup.Node
+		for _, node := range linkElements.Results() {
+			linkPath := node.GetAttribute("href")
+			linkPaths = append(linkPaths, b.resolve(linkPath))
+			if linkNode != nil {
+				node.Unlink()
+			} else {
+				linkNode = node
+			}
+			preloadLinkElements := xpath.Eval(fmt.Sprintf("/html/head/link[@rel='preload' and @href='%s']", linkPath))
+			for _, preloadNode := range preloadLinkElements.Results() {
+				preloadNode.Unlink()
+			}
+			if linkNode != nil && len(linkPaths) > 0 {
+				result, err := b.bundle(linkNode, linkPaths, cssLoaders, ".css")
+				if err != nil {
+					return ctx, Continue, err
+				}
+				newNode := document.NewNode(nil, "style", result)
+				linkNode.Replace(newNode)
+			}
 		}
-		if linkNode != nil && len(linkPaths) > 0 {
-			result := bundle(linkPaths, rootPath, cssLoaders)
+
+		linkElements = xpath.Eval("/html/body/link[@rel='stylesheet' and @href and string-length(@href) != 0]")
+		for _, node := range linkElements.Results() {
+			linkPath := node.GetAttribute("href")
+			result, err := b.bundle(node, []string{b.resolve(linkPath)}, cssLoaders, ".css")
+			if err != nil {
+				return ctx, Continue, err
+			}
 			newNode := document.NewNode(nil, "style", result)
-			linkNode.Replace(newNode)
+			node.Replace(newNode)
 		}
-	}
 
-	linkElements = xpath.Eval("/html/body/link[@rel='stylesheet' and @href and string-length(@href) != 0]")
-	for _, node := range linkElements.Results() {
-		linkPath := node.GetAttribute("href")
-		result := bundle([]string{linkPath}, rootPath, cssLoaders)
-		newNode := document.NewNode(nil, "style", result)
-		node.Replace(newNode)
-	}
-
-	var scriptElements *markup.XPathObject
+		scriptElements = xpath.Eval("/html/head/script[@src and string-length(@src) != 0 and @type='module']")
+		scriptPaths := []string{}
+		var scriptNode *markup.Node
+		for _, node := range scriptElements.Results() {
+			srcAttr := node.HasAttribute("src")
+			scriptPath := srcAttr.Children().String()
+			scriptPaths = append(scriptPaths, b.resolve(scriptPath))
+			if scriptNode != nil {
+				node.Unlink()
+			} else {
+				scriptNode = node
+			}
+			preloadScriptElements := xpath.Eval(fmt.Sprintf("/html/head/link[@rel='preload' and @href='%s']", scriptPath))
+			for _, preloadNode := range preloadScriptElements.Results() {
+				preloadNode.Unlink()
+			}
+			if scriptNode != nil && len(scriptPaths) > 0 {
+				result, err := b.bundle(scriptNode, scriptPaths, jsLoaders, ".js")
+				if err != nil {
+					return ctx, Continue, err
+				}
+				scriptNode.SetContent(result)
+				markup.RemoveAttribute(srcAttr)
+			}
+		}
 
-	scriptElements = xpath.Eval("/html/head/script[@src and string-length(@src) != 0 and @type='module']")
-	scriptPaths := []string{}
-	var scriptNode *markup.Node
-	for _, node := range scriptElements.Results() {
-		srcAttr := node.HasAttribute("src")
-		scriptPath := srcAttr.Children().String()
-		scriptPaths = append(scriptPaths, scriptPath)
-		if scriptNode != nil {
-			node.Unlink()
-		} else {
-			scriptNode = node
-		}
-		preloadScriptElements := xpath.Eval(fmt.Sprintf("/html/head/link[@rel='preload' and @href='%s']", scriptPath))
-		for _, preloadNode := range preloadScriptElements.Results() {
-			preloadNode.Unlink()
-		}
-		if scriptNode != nil && len(scriptPaths) > 0 {
-			result := bundle(scriptPaths, rootPath, jsLoaders)
-			scriptNode.SetContent(result)
+		scriptElements = xpath.Eval("/html/body/script[@src and string-length(@src) != 0 and @type='module']")
+		for _, node := range scriptElements.Results() {
+			srcAttr := node.HasAttribute("src")
+			scriptPath := srcAttr.Children().String()
+			result, err := b.bundle(node, []string{b.resolve(scriptPath)}, jsLoaders, ".js")
+			if err != nil {
+				return ctx, Continue, err
+			}
+			node.SetContent(result)
 			markup.RemoveAttribute(srcAttr)
 		}
 	}
 
-	scriptElements = xpath.Eval("/html/body/script[@src and string-length(@src) != 0 and @type='module']")
-	for _, node := range scriptElements.Results() {
-		srcAttr := node.HasAttribute("src")
-		scriptPath := srcAttr.Children().String()
-		result := bundle([]string{scriptPath}, rootPath, jsLoaders)
-		node.SetContent(result)
-		markup.RemoveAttribute(srcAttr)
-	}
-
+	// classic scripts have always been resolved from the directory of the
+	// input file
 	scriptElements = xpath.Eval("//script[@src and string-length(@src) != 0 and not(@type)]")
 	for _, node := range scriptElements.Results() {
 		srcAttr := node.HasAttribute("src")
-		scriptPath := srcAttr.Children().String()
-		if strings.HasPrefix(scriptPath, "/") {
-			scriptPath = filepath.Join(rootPath, scriptPath)
-		} else {
-			scriptPath = filepath.Join(filepath.Dir(inPath), scriptPath)
+		scriptPath := b.resolveIn(filepath.Dir(inPath), srcAttr.Children().String())
+		if b.config.Classic == "bundle" {
+			result, err := b.bundleClassic(node, scriptPath)
+			if err != nil {
+				return ctx, Continue, err
+			}
+			node.SetContent(result)
+			markup.RemoveAttribute(srcAttr)
+			continue
 		}
 		contents, err := os.ReadFile(scriptPath)
 		if err != nil {
-			return ctx, Continue, errors.New("cannot read script path")
+			ReportDiagnostic(ctx, Diagnostic{
+				Severity: SeverityError,
+				File:     inPath,
+				Element:  node.Path(),
+				Message:  err.Error(),
+			})
+			return ctx, Continue, errors.New(fmt.Sprintf("%s: cannot read script path in %s", inPath, node.Path()))
 		}
 		node.SetContent(strings.TrimSpace(string(contents)))
 		markup.RemoveAttribute(srcAttr)
@@ -164,10 +810,14 @@ func init() {
 	cssLoaders = make(map[string]api.Loader)
 	cssLoaders[""] = api.LoaderCSS
 
-	jsLoaders := make(map[string]api.Loader)
+	jsLoaders = make(map[string]api.Loader)
 	jsLoaders[""] = api.LoaderJS
 	jsLoaders[".ts"] = api.LoaderTS
 	jsLoaders[".d.ts"] = api.LoaderTS
+	jsLoaders[".tsx"] = api.LoaderTSX
+	jsLoaders[".jsx"] = api.LoaderJSX
+	jsLoaders[".json"] = api.LoaderJSON
+	jsLoaders[".txt"] = api.LoaderText
 
 	Registry.Register("bundle", TransformBundle)
 }
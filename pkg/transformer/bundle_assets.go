@@ -0,0 +1,159 @@
+package transformer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+var (
+	defaultBundleFonts  = []string{".woff", ".woff2", ".ttf", ".otf", ".eot"}
+	defaultBundleImages = []string{".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".ico"}
+)
+
+// BundleAssetConfig sets how files referenced with url() in stylesheets, or
+// imported by scripts, are bundled. Mode file copies them with a content hash,
+// dataurl inlines them and external leaves them where they are. Files smaller
+// than limit bytes are inlined in file mode.
+type BundleAssetConfig struct {
+	Mode       string   `yaml:"mode"`
+	Limit      int64    `yaml:"limit"`
+	Extensions []string `yaml:"extensions"`
+}
+
+func (c BundleAssetConfig) merge(profile BundleAssetConfig) BundleAssetConfig {
+	if profile.Mode != "" {
+		c.Mode = profile.Mode
+	}
+	if profile.Limit != 0 {
+		c.Limit = profile.Limit
+	}
+	if len(profile.Extensions) > 0 {
+		c.Extensions = profile.Extensions
+	}
+	return c
+}
+
+// filter matches the asset extensions that have no loader configured.
+func (c BundleAssetConfig) filter(defaults []string, loaders map[string]string) string {
+	extensions := c.Extensions
+	if len(extensions) == 0 {
+		extensions = defaults
+	}
+	patterns := []string{}
+	for _, ext := range extensions {
+		ext = strings.TrimPrefix(ext, ".")
+		if _, ok := loaders[ext]; ok {
+			continue
+		}
+		if _, ok := loaders["."+ext]; ok {
+			continue
+		}
+		patterns = append(patterns, regexp.QuoteMeta(ext))
+	}
+	if len(patterns) == 0 {
+		return ""
+	}
+	return `\.(` + strings.Join(patterns, "|") + `)$`
+}
+
+func (c BundleAssetConfig) loader(path string) (api.Loader, error) {
+	switch c.Mode {
+	case "", "file":
+		if c.Limit > 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				return api.LoaderNone, err
+			}
+			if info.Size() < c.Limit {
+				return api.LoaderDataURL, nil
+			}
+		}
+		return api.LoaderFile, nil
+	case "dataurl":
+		return api.LoaderDataURL, nil
+	default:
+		return api.LoaderNone, errors.New(fmt.Sprintf("unknown bundle asset mode: %s", c.Mode))
+	}
+}
+
+// bundleAssetPlugin loads fonts and images with the loader their configuration
+// asks for. External assets keep a reference to their place in the output tree,
+// which mirrors the project tree, made by assetURL.
+func bundleAssetPlugin(rootPath string, config BundleConfig, assetURL func(string, string) (string, error)) api.Plugin {
+	return api.Plugin{
+		Name: "gostatic-assets",
+		Setup: func(build api.PluginBuild) {
+			for _, asset := range []struct {
+				config   BundleAssetConfig
+				defaults []string
+			}{
+				{config.Fonts, defaultBundleFonts},
+				{config.Images, defaultBundleImages},
+			} {
+				assetConfig := asset.config
+				filter := assetConfig.filter(asset.defaults, config.Loaders)
+				if filter == "" {
+					continue
+				}
+				if assetConfig.Mode == "external" {
+					build.OnResolve(api.OnResolveOptions{Filter: filter}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+						if !isLocalURL(args.Path) {
+							return api.OnResolveResult{Path: args.Path, External: true}, nil
+						}
+						refPath, suffix := splitURL(args.Path)
+						assetPath := filepath.Join(args.ResolveDir, filepath.FromSlash(refPath))
+						if strings.HasPrefix(refPath, "/") {
+							assetPath = filepath.Join(rootPath, filepath.FromSlash(refPath))
+						}
+						relPath, err := filepath.Rel(rootPath, assetPath)
+						if err != nil {
+							return api.OnResolveResult{}, err
+						}
+						url, err := assetURL(args.Importer, relPath)
+						if err != nil {
+							return api.OnResolveResult{}, err
+						}
+						return api.OnResolveResult{Path: url + suffix, External: true}, nil
+					})
+					continue
+				}
+				build.OnLoad(api.OnLoadOptions{Filter: filter, Namespace: "file"}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					loader, err := assetConfig.loader(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					data, err := os.ReadFile(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					contents := string(data)
+					return api.OnLoadResult{Contents: &contents, Loader: loader}, nil
+				})
+			}
+			// references starting with a slash are relative to the project root
+			build.OnResolve(api.OnResolveOptions{Filter: `^/`}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if _, err := os.Stat(args.Path); err == nil || args.Kind == api.ResolveEntryPoint {
+					return api.OnResolveResult{}, nil
+				}
+				result := build.Resolve("."+args.Path, api.ResolveOptions{
+					ResolveDir: rootPath,
+					Kind:       args.Kind,
+					Importer:   args.Importer,
+				})
+				return api.OnResolveResult{
+					Errors:   result.Errors,
+					Warnings: result.Warnings,
+					Path:     result.Path,
+					External: result.External,
+					Suffix:   result.Suffix,
+				}, nil
+			})
+		},
+	}
+}
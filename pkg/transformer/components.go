@@ -0,0 +1,304 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+const (
+	defaultComponentsDir   string = "components"
+	defaultComponentsDepth int    = 10
+)
+
+var componentProp = regexp.MustCompile(`{{\s*([\w.:-]+)\s*}}`)
+
+// ComponentsConfig is the configuration of the components transformation.
+// Dir is the directory of the component files relative to the root and depth
+// limits how deeply components may be nested in each other.
+type ComponentsConfig struct {
+	Dir   string `yaml:"dir"`
+	Depth int    `yaml:"depth"`
+}
+
+// component is a template file expanding the elements using it, either an
+// HTML fragment or an XSL stylesheet.
+type component struct {
+	path     string
+	fragment string
+	style    *markup.Stylesheet
+}
+
+// loadComponent returns the component of a name, nil when the components
+// directory has no file for it. Components are read once per build.
+func loadComponent(ctx context.Context, dir string, name string) (*component, error) {
+	value, err := buildState(ctx).Load("component:"+filepath.Join(dir, name), func() (interface{}, error) {
+		path := filepath.Join(dir, name+".html")
+		if data, err := os.ReadFile(path); err == nil {
+			return &component{path: path, fragment: string(data)}, nil
+		}
+		path = filepath.Join(dir, name+".xsl")
+		if _, err := os.Stat(path); err != nil {
+			return (*component)(nil), nil
+		}
+		style := markup.ParseStylesheetFile(path)
+		if style == nil {
+			return nil, errors.New(fmt.Sprintf("unable to parse component stylesheet: %s", path))
+		}
+		buildState(ctx).OnClose(style.Free)
+		return &component{path: path, style: style}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*component), nil
+}
+
+// componentName is the value of the is attribute of an element or the name
+// of a custom element.
+func componentName(node *markup.Node) string {
+	if name := node.GetAttribute("is"); name != "" {
+		return name
+	}
+	if strings.Contains(node.Name(), "-") {
+		return node.Name()
+	}
+	return ""
+}
+
+// componentProps are the attributes of the element using a component.
+func componentProps(host *markup.Node) map[string]string {
+	props := make(map[string]string)
+	for attr := host.Attributes(); attr != nil; attr = attr.Next() {
+		switch name := attr.Name(); name {
+		case "is", "slot":
+		default:
+			props[name] = host.GetAttribute(name)
+		}
+	}
+	return props
+}
+
+// children returns the child nodes of an element.
+func children(node *markup.Node) []*markup.Node {
+	nodes := []*markup.Node{}
+	for child := node.Children(); child != nil; child = child.Next() {
+		nodes = append(nodes, child)
+	}
+	return nodes
+}
+
+// fillSlots moves the children of the host into the slot elements of an
+// expanded fragment. Children with a slot attribute go to the slot of that
+// name, the others to the unnamed slot. Slots nothing is assigned to keep
+// their own content.
+func fillSlots(container *markup.Node, host *markup.Node) {
+	xpath := markup.NewXPathContext(container.Document())
+	defer xpath.Free()
+
+	slots := xpath.Eval(container.Path() + "//slot")
+	if slots == nil {
+		return
+	}
+	defer slots.Free()
+
+	assigned := make(map[string][]*markup.Node)
+	for _, child := range children(host) {
+		name := ""
+		if child.Type() == markup.XML_ELEMENT_NODE {
+			name = child.GetAttribute("slot")
+			child.UnsetAttribute("slot")
+		}
+		assigned[name] = append(assigned[name], child)
+	}
+
+	for _, slot := range slots.Results() {
+		nodes, ok := assigned[slot.GetAttribute("name")]
+		if !ok {
+			nodes = children(slot)
+		}
+		delete(assigned, slot.GetAttribute("name"))
+		for _, node := range nodes {
+			node.Unlink()
+			slot.AddPrevSibling(node)
+		}
+		slot.Unlink()
+		slot.Free()
+	}
+}
+
+// expandFragment replaces the host with the fragment of a component, its
+// {{name}} placeholders replaced by the props of the host.
+func expandFragment(document *markup.Document, host *markup.Node, c *component) error {
+	props := componentProps(host)
+	fragment := componentProp.ReplaceAllStringFunc(c.fragment, func(placeholder string) string {
+		return html.EscapeString(props[componentProp.FindStringSubmatch(placeholder)[1]])
+	})
+
+	container := document.NewNode(nil, "div", "")
+	host.AddPrevSibling(container)
+	parser := markup.CreateHTML5Parser(document, container)
+	if parser == nil {
+		return errors.New(fmt.Sprintf("unable to parse component: %s", c.path))
+	}
+	defer parser.Free()
+	if parser.ParseChunk(fragment) < 0 || parser.Terminate() < 0 {
+		return errors.New(fmt.Sprintf("unable to parse component: %s", c.path))
+	}
+
+	fillSlots(container, host)
+	for _, node := range children(container) {
+		node.Unlink()
+		container.AddPrevSibling(node)
+	}
+	container.Unlink()
+	container.Free()
+	return nil
+}
+
+// expandStylesheet replaces the host with the result of applying the
+// stylesheet of a component to a copy of it. The props are passed as string
+// parameters.
+func expandStylesheet(ctx context.Context, document *markup.Document, host *markup.Node, c *component) error {
+	input := markup.NewDoc("1.0")
+	defer input.Free()
+	input.SetRoot(input.CopyNode(host, 1))
+
+	strparams := []string{}
+	for name, value := range componentProps(host) {
+		strparams = append(strparams, name, value)
+	}
+
+	logger, _ := ctx.Value(builder_context.LoggerContextKey).(*log.Logger)
+	transformCtx := markup.NewTransformContext(c.style, input, logger)
+	if transformCtx == nil {
+		return errors.New(fmt.Sprintf("unable to apply component stylesheet: %s", c.path))
+	}
+	defer transformCtx.Free()
+
+	result := transformCtx.ApplyStylesheet(c.style, input, []string{}, strparams)
+	if result == nil {
+		return errors.New(fmt.Sprintf("unable to apply component stylesheet: %s", c.path))
+	}
+	defer result.Free()
+
+	for node := result.Node.Children(); node != nil; node = node.Next() {
+		if node.Type() == markup.XML_DTD_NODE {
+			continue
+		}
+		host.AddPrevSibling(document.CopyNode(node, 1))
+	}
+	return nil
+}
+
+// componentHosts returns the outermost elements using a component.
+func componentHosts(ctx context.Context, document *markup.Document, dir string) ([]*markup.Node, []*component, error) {
+	hosts := []*markup.Node{}
+	components := []*component{}
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	elements := xpath.Eval("//*[(@is or contains(local-name(), '-')) and not(ancestor::template)]")
+	if elements == nil {
+		return hosts, components, nil
+	}
+	defer elements.Free()
+
+	outermost := []string{}
+	for _, node := range elements.Results() {
+		name := componentName(node)
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			continue
+		}
+		c, err := loadComponent(ctx, dir, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c == nil {
+			continue
+		}
+		path := node.Path()
+		nested := false
+		for _, parent := range outermost {
+			if strings.HasPrefix(path, parent+"/") {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outermost = append(outermost, path)
+			hosts = append(hosts, node)
+			components = append(components, c)
+		}
+	}
+	return hosts, components, nil
+}
+
+// TransformComponents expands the custom elements (<x-card>) and elements
+// with an is attribute (is="card-component") that have a component file,
+// x-card.html or card-component.xsl, in the components directory. The
+// optional argument is the directory, relative to the root.
+func TransformComponents(ctx context.Context, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document")
+	}
+	rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+
+	config := siteConfig(ctx).Components
+	if len(args) > 0 && args[0] != "" {
+		config.Dir = args[0]
+	}
+	if config.Dir == "" {
+		config.Dir = defaultComponentsDir
+	}
+	if config.Depth <= 0 {
+		config.Depth = defaultComponentsDepth
+	}
+	dir := config.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(rootPath, dir)
+	}
+
+	for depth := 0; ; depth++ {
+		hosts, components, err := componentHosts(ctx, document, dir)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		if len(hosts) == 0 {
+			break
+		}
+		if depth == config.Depth {
+			return ctx, Continue, errors.New(fmt.Sprintf("components nested deeper than %d: %s", config.Depth, componentName(hosts[0])))
+		}
+		for i, host := range hosts {
+			c := components[i]
+			addDependency(ctx, c.path)
+			if c.style != nil {
+				err = expandStylesheet(ctx, document, host, c)
+			} else {
+				err = expandFragment(document, host, c)
+			}
+			if err != nil {
+				return ctx, Continue, err
+			}
+			host.Unlink()
+			host.Free()
+		}
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("components", TransformComponents)
+}
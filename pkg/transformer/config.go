@@ -1,59 +1,209 @@
 package transformer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	builder_context "gostatic/pkg/builder/context"
 	"gostatic/pkg/markdown"
 	"gostatic/pkg/markup"
 
 	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/styles"
 	"github.com/evanw/esbuild/pkg/api"
 	pikchr "github.com/jchenry/goldmark-pikchr"
 	mathjax "github.com/litao91/goldmark-mathjax"
 	fences "github.com/stefanfritsch/goldmark-fences"
 	"github.com/yuin/goldmark"
 	highlighting "github.com/yuin/goldmark-highlighting"
+	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
 	frontmatter "go.abhg.dev/goldmark/frontmatter"
 )
 
-func configBundleOptions(rootPath string, loaders map[string]api.Loader) api.BuildOptions {
-	return api.BuildOptions{
-		Color:             api.ColorIfTerminal,
-		LogLevel:          api.LogLevelDebug,
-		Sourcemap:         api.SourceMapNone,
-		Target:            api.ESNext,
-		MinifyWhitespace:  true,
-		MinifyIdentifiers: true,
-		MinifySyntax:      true,
-		LineLimit:         80,
-		Charset:           api.CharsetUTF8,
-		TreeShaking:       api.TreeShakingTrue,
-		LegalComments:     api.LegalCommentsInline,
-		Bundle:            true,
-		AbsWorkingDir:     rootPath,
-		Platform:          api.PlatformBrowser,
-		Format:            api.FormatESModule,
-		Loader:            loaders,
+// Config holds the site level settings of a build.yaml file.
+type Config struct {
+	Output      string                  `yaml:"output"`
+	URL         string                  `yaml:"url"`
+	Fingerprint FingerprintConfig       `yaml:"fingerprint"`
+	Bundle      BundleConfig            `yaml:"bundle"`
+	Images      ImagesConfig            `yaml:"images"`
+	Security    SecurityConfig          `yaml:"security"`
+	Markdown    MarkdownConfig          `yaml:"markdown"`
+	Toc         TocConfig               `yaml:"toc"`
+	Highlight   HighlightConfig         `yaml:"highlight"`
+	Minify      MinifyConfig            `yaml:"minify"`
+	Sitemap     SitemapConfig           `yaml:"sitemap"`
+	Feeds       map[string]FeedConfig   `yaml:"feeds"`
+	Search      SearchConfig            `yaml:"search"`
+	LinkCheck   LinkCheckConfig         `yaml:"linkcheck"`
+	Lint        LintConfig              `yaml:"lint"`
+	Validate    ValidateConfig          `yaml:"validate"`
+	Components  ComponentsConfig        `yaml:"components"`
+	GoTemplate  GoTemplateConfig        `yaml:"goTemplate"`
+	Script      ScriptConfig            `yaml:"script"`
+	Plugins     map[string]PluginConfig `yaml:"plugins"`
+	I18n        I18nConfig              `yaml:"i18n"`
+}
+
+func siteConfig(ctx context.Context) *Config {
+	if config, ok := ctx.Value(builder_context.ConfigContextKey).(*Config); ok {
+		return config
 	}
+	return &Config{}
 }
 
-func configMarkdownConverter() *markdown.Converter {
-	return markdown.New(
-		goldmark.WithExtensions(
-			&frontmatter.Extender{},
-			&fences.Extender{},
-			&pikchr.Extender{},
-			mathjax.MathJax,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle("monokai"),
+func configBundleOptions(rootPath string, loaders map[string]api.Loader, config BundleConfig, assetURL func(string, string) (string, error)) (api.BuildOptions, error) {
+	loaders, err := bundleLoaderMap(loaders, config.Loaders)
+	if err != nil {
+		return api.BuildOptions{}, err
+	}
+
+	options := api.BuildOptions{
+		Color:         api.ColorIfTerminal,
+		LogLevel:      api.LogLevelSilent,
+		Sourcemap:     api.SourceMapNone,
+		Target:        api.ESNext,
+		Charset:       api.CharsetUTF8,
+		TreeShaking:   api.TreeShakingTrue,
+		LegalComments: api.LegalCommentsInline,
+		Bundle:        true,
+		AbsWorkingDir: rootPath,
+		Platform:      api.PlatformBrowser,
+		Format:        api.FormatESModule,
+		Loader:        loaders,
+		Define:        config.Define,
+		Inject:        config.Inject,
+		External:      config.External,
+		JSXFactory:    config.JSXFactory,
+		JSXFragment:   config.JSXFragment,
+		Plugins:       []api.Plugin{bundleAssetPlugin(rootPath, config, assetURL)},
+	}
+
+	if config.Minify == nil || *config.Minify {
+		options.MinifyWhitespace = true
+		options.MinifyIdentifiers = true
+		options.MinifySyntax = true
+		options.LineLimit = 80
+	}
+
+	if len(config.Target) > 0 {
+		target, engines, err := bundleTarget(config.Target)
+		if err != nil {
+			return options, err
+		}
+		options.Target = target
+		options.Engines = engines
+	}
+
+	switch config.Sourcemap {
+	case "", "none":
+	case "inline":
+		options.Sourcemap = api.SourceMapInline
+	case "external":
+		options.Sourcemap = api.SourceMapExternal
+	case "linked":
+		options.Sourcemap = api.SourceMapLinked
+	case "both":
+		options.Sourcemap = api.SourceMapInlineAndExternal
+	default:
+		return options, errors.New(fmt.Sprintf("unknown bundle sourcemap mode: %s", config.Sourcemap))
+	}
+
+	switch config.Format {
+	case "", "esm":
+	case "iife":
+		options.Format = api.FormatIIFE
+	case "cjs":
+		options.Format = api.FormatCommonJS
+	default:
+		return options, errors.New(fmt.Sprintf("unknown bundle format: %s", config.Format))
+	}
+
+	switch config.LogLevel {
+	case "", "silent":
+	case "debug":
+		options.LogLevel = api.LogLevelDebug
+	case "verbose":
+		options.LogLevel = api.LogLevelVerbose
+	case "info":
+		options.LogLevel = api.LogLevelInfo
+	case "warning":
+		options.LogLevel = api.LogLevelWarning
+	case "error":
+		options.LogLevel = api.LogLevelError
+	default:
+		return options, errors.New(fmt.Sprintf("unknown bundle log level: %s", config.LogLevel))
+	}
+
+	return options, nil
+}
+
+var markdownExtensions = map[string]goldmark.Extender{
+	"frontmatter":    &frontmatter.Extender{},
+	"fences":         &fences.Extender{},
+	"pikchr":         &pikchr.Extender{},
+	"mathjax":        mathjax.MathJax,
+	"table":          extension.Table,
+	"strikethrough":  extension.Strikethrough,
+	"tasklist":       extension.TaskList,
+	"linkify":        extension.Linkify,
+	"gfm":            extension.GFM,
+	"footnote":       extension.Footnote,
+	"typographer":    extension.Typographer,
+	"definitionlist": extension.DefinitionList,
+	"cjk":            extension.CJK,
+}
+
+func configMarkdownConverter(config MarkdownConfig) (*markdown.Converter, error) {
+	names := config.Extensions
+	if names == nil {
+		names = defaultMarkdownExtensions
+	}
+
+	extensions := []goldmark.Extender{}
+	for _, name := range names {
+		if name == "highlighting" {
+			style := config.Highlight.Style
+			if style == "" {
+				style = defaultMarkdownStyle
+			}
+			if _, ok := styles.Registry[style]; !ok {
+				return nil, errors.New(fmt.Sprintf("unknown highlight style: %s", style))
+			}
+			extensions = append(extensions, highlighting.NewHighlighting(
+				highlighting.WithStyle(style),
 				highlighting.WithFormatOptions(
-					chromahtml.WithLineNumbers(true),
+					chromahtml.WithLineNumbers(config.Highlight.LineNumbers == nil || *config.Highlight.LineNumbers),
+					chromahtml.WithClasses(config.Highlight.Classes != nil && *config.Highlight.Classes),
 				),
-			),
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-	)
+			))
+			continue
+		}
+		extender, ok := markdownExtensions[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown markdown extension: %s", name))
+		}
+		extensions = append(extensions, extender)
+	}
+
+	parserOptions := []parser.Option{}
+	if config.HeadingIDs == nil || *config.HeadingIDs {
+		parserOptions = append(parserOptions, parser.WithAutoHeadingID())
+	}
+
+	rendererOptions := []renderer.Option{}
+	if config.Unsafe != nil && *config.Unsafe {
+		rendererOptions = append(rendererOptions, html.WithUnsafe())
+	}
+
+	return markdown.New(
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(parserOptions...),
+		goldmark.WithRendererOptions(rendererOptions...),
+	), nil
 }
 
 func init() {
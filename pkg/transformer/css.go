@@ -0,0 +1,249 @@
+package transformer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// cssSelector translates simple CSS selectors into XPath expressions: type,
+// universal, id, class and attribute selectors, :first-child, :last-child,
+// the descendant, child and sibling combinators and selector lists.
+type cssSelector struct {
+	source string
+	pos    int
+}
+
+// cssToXPath returns an expression selecting the descendants of the context
+// node matching a selector.
+func cssToXPath(selector string) (string, error) {
+	s := &cssSelector{source: strings.TrimSpace(selector)}
+	groups := []string{}
+	for {
+		group, err := s.group()
+		if err != nil {
+			return "", err
+		}
+		groups = append(groups, "."+group)
+		s.skipSpace()
+		if s.done() {
+			break
+		}
+		if s.peek() != ',' {
+			return "", s.error()
+		}
+		s.pos++
+	}
+	return strings.Join(groups, " | "), nil
+}
+
+func (s *cssSelector) error() error {
+	return errors.New(fmt.Sprintf("unsupported css selector at %d: %s", s.pos, s.source))
+}
+
+func (s *cssSelector) done() bool {
+	return s.pos >= len(s.source)
+}
+
+func (s *cssSelector) peek() byte {
+	if s.done() {
+		return 0
+	}
+	return s.source[s.pos]
+}
+
+func (s *cssSelector) skipSpace() bool {
+	start := s.pos
+	for !s.done() && strings.IndexByte(" \t\n\r", s.peek()) >= 0 {
+		s.pos++
+	}
+	return s.pos > start
+}
+
+func isIdentByte(c byte) bool {
+	return c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func (s *cssSelector) ident() string {
+	start := s.pos
+	for !s.done() && isIdentByte(s.peek()) {
+		s.pos++
+	}
+	return s.source[start:s.pos]
+}
+
+// value reads a quoted string or an identifier.
+func (s *cssSelector) value() (string, error) {
+	if quote := s.peek(); quote == '"' || quote == '\'' {
+		end := strings.IndexByte(s.source[s.pos+1:], quote)
+		if end < 0 {
+			return "", s.error()
+		}
+		value := s.source[s.pos+1 : s.pos+1+end]
+		s.pos += end + 2
+		return value, nil
+	}
+	if value := s.ident(); value != "" {
+		return value, nil
+	}
+	return "", s.error()
+}
+
+// xpathLiteral quotes a string for an XPath expression.
+func xpathLiteral(value string) string {
+	if !strings.Contains(value, "'") {
+		return "'" + value + "'"
+	}
+	if !strings.Contains(value, `"`) {
+		return `"` + value + `"`
+	}
+	parts := strings.Split(value, "'")
+	return "concat('" + strings.Join(parts, `', "'", '`) + "')"
+}
+
+func (s *cssSelector) group() (string, error) {
+	var builder strings.Builder
+	combinator := "//"
+	for {
+		s.skipSpace()
+		step, err := s.compound(combinator)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(step)
+
+		space := s.skipSpace()
+		if s.done() || s.peek() == ',' {
+			return builder.String(), nil
+		}
+		switch s.peek() {
+		case '>':
+			combinator = "/"
+			s.pos++
+		case '+', '~':
+			combinator = string(s.peek())
+			s.pos++
+		default:
+			if !space {
+				return "", s.error()
+			}
+			combinator = "//"
+		}
+	}
+}
+
+func (s *cssSelector) compound(combinator string) (string, error) {
+	tag := "*"
+	if s.peek() == '*' {
+		s.pos++
+	} else if name := s.ident(); name != "" {
+		tag = strings.ToLower(name)
+	} else if s.done() || strings.IndexByte("#.[:", s.peek()) < 0 {
+		return "", s.error()
+	}
+
+	predicates := []string{}
+	for !s.done() {
+		switch s.peek() {
+		case '#':
+			s.pos++
+			id := s.ident()
+			if id == "" {
+				return "", s.error()
+			}
+			predicates = append(predicates, fmt.Sprintf("[@id=%s]", xpathLiteral(id)))
+		case '.':
+			s.pos++
+			class := s.ident()
+			if class == "" {
+				return "", s.error()
+			}
+			predicates = append(predicates, fmt.Sprintf("[contains(concat(' ', normalize-space(@class), ' '), %s)]", xpathLiteral(" "+class+" ")))
+		case '[':
+			s.pos++
+			predicate, err := s.attribute()
+			if err != nil {
+				return "", err
+			}
+			predicates = append(predicates, predicate)
+		case ':':
+			s.pos++
+			switch s.ident() {
+			case "first-child":
+				predicates = append(predicates, "[not(preceding-sibling::*)]")
+			case "last-child":
+				predicates = append(predicates, "[not(following-sibling::*)]")
+			default:
+				return "", s.error()
+			}
+		default:
+			return s.step(combinator, tag, predicates), nil
+		}
+	}
+	return s.step(combinator, tag, predicates), nil
+}
+
+func (s *cssSelector) step(combinator string, tag string, predicates []string) string {
+	switch combinator {
+	case "+":
+		if tag != "*" {
+			predicates = append([]string{"[self::" + tag + "]"}, predicates...)
+		}
+		return "/following-sibling::*[1]" + strings.Join(predicates, "")
+	case "~":
+		return "/following-sibling::" + tag + strings.Join(predicates, "")
+	default:
+		return combinator + tag + strings.Join(predicates, "")
+	}
+}
+
+func (s *cssSelector) attribute() (string, error) {
+	s.skipSpace()
+	name := s.ident()
+	if name == "" {
+		return "", s.error()
+	}
+	attr := "@" + name
+	s.skipSpace()
+	if s.peek() == ']' {
+		s.pos++
+		return "[" + attr + "]", nil
+	}
+
+	operator := ""
+	if strings.IndexByte("~^$*|", s.peek()) >= 0 {
+		operator = string(s.peek())
+		s.pos++
+	}
+	if s.peek() != '=' {
+		return "", s.error()
+	}
+	s.pos++
+	s.skipSpace()
+	value, err := s.value()
+	if err != nil {
+		return "", err
+	}
+	s.skipSpace()
+	if s.peek() != ']' {
+		return "", s.error()
+	}
+	s.pos++
+
+	literal := xpathLiteral(value)
+	switch operator {
+	case "~":
+		return fmt.Sprintf("[contains(concat(' ', normalize-space(%s), ' '), %s)]", attr, xpathLiteral(" "+value+" ")), nil
+	case "^":
+		return fmt.Sprintf("[starts-with(%s, %s)]", attr, literal), nil
+	case "$":
+		return fmt.Sprintf("[substring(%s, string-length(%s) - %d) = %s]", attr, attr, utf8.RuneCountInString(value)-1, literal), nil
+	case "*":
+		return fmt.Sprintf("[contains(%s, %s)]", attr, literal), nil
+	case "|":
+		return fmt.Sprintf("[%s = %s or starts-with(%s, %s)]", attr, literal, attr, xpathLiteral(value+"-")), nil
+	default:
+		return fmt.Sprintf("[%s = %s]", attr, literal), nil
+	}
+}
@@ -0,0 +1,81 @@
+package transformer
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	builder_context "gostatic/pkg/builder/context"
+)
+
+// Dependencies records the files other than their input that pages are built
+// from, like component templates, so that a watch rebuilds when one of them
+// changes.
+type Dependencies struct {
+	mutex sync.Mutex
+	files map[string]map[string]bool
+}
+
+func NewDependencies() *Dependencies {
+	return &Dependencies{files: make(map[string]map[string]bool)}
+}
+
+// Add records that the page read from inPath depends on path.
+func (d *Dependencies) Add(inPath string, path string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if d.files[path] == nil {
+		d.files[path] = make(map[string]bool)
+	}
+	d.files[path][inPath] = true
+}
+
+// Reset forgets the dependencies of the previous build.
+func (d *Dependencies) Reset() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.files = make(map[string]map[string]bool)
+}
+
+// Files returns the files pages depend on, sorted.
+func (d *Dependencies) Files() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	files := make([]string, 0, len(d.files))
+	for path := range d.files {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Dependents returns the input files of the pages depending on path, sorted.
+func (d *Dependencies) Dependents(path string) []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	pages := make([]string, 0, len(d.files[path]))
+	for inPath := range d.files[path] {
+		pages = append(pages, inPath)
+	}
+	sort.Strings(pages)
+	return pages
+}
+
+// addDependency records that the page being built depends on path.
+func addDependency(ctx context.Context, path string) {
+	if dependencies, ok := ctx.Value(builder_context.DependenciesContextKey).(*Dependencies); ok {
+		inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+		dependencies.Add(inPath, path)
+	}
+}
@@ -0,0 +1,143 @@
+package transformer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	builder_context "gostatic/pkg/builder/context"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota + 1
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic is a message about an input file found while building it.
+// Element is the path of the document node the message is about.
+type Diagnostic struct {
+	Severity Severity
+	File     string
+	Line     int
+	Column   int
+	Snippet  string
+	Element  string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	var builder strings.Builder
+	if d.File != "" {
+		builder.WriteString(d.File)
+		if d.Line > 0 {
+			builder.WriteString(fmt.Sprintf(":%d:%d", d.Line, d.Column))
+		}
+		builder.WriteString(": ")
+	}
+	builder.WriteString(d.Message)
+	if d.Element != "" {
+		builder.WriteString(fmt.Sprintf(" (%s)", d.Element))
+	}
+	if d.Snippet != "" {
+		builder.WriteString("\n    ")
+		builder.WriteString(d.Snippet)
+		if d.Column > 0 {
+			builder.WriteString("\n    ")
+			builder.WriteString(strings.Repeat(" ", d.Column-1))
+			builder.WriteString("^")
+		}
+	}
+	return builder.String()
+}
+
+// Diagnostics collects the diagnostics reported during a build.
+type Diagnostics struct {
+	mutex  sync.Mutex
+	list   []Diagnostic
+	logger *log.Logger
+}
+
+func NewDiagnostics(logger *log.Logger) *Diagnostics {
+	return &Diagnostics{logger: logger}
+}
+
+func (d *Diagnostics) Report(diagnostic Diagnostic) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// steps checking the same document report a problem once
+	for i := range d.list {
+		if d.list[i] == diagnostic {
+			return
+		}
+	}
+	d.list = append(d.list, diagnostic)
+	logDiagnostic(d.logger, diagnostic)
+}
+
+func (d *Diagnostics) List() []Diagnostic {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	list := make([]Diagnostic, len(d.list))
+	copy(list, d.list)
+	return list
+}
+
+func (d *Diagnostics) Count(severity Severity) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	count := 0
+	for i := range d.list {
+		if d.list[i].Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+func logDiagnostic(logger *log.Logger, diagnostic Diagnostic) {
+	if logger == nil {
+		return
+	}
+	prefix := logger.Prefix()
+	switch diagnostic.Severity {
+	case SeverityInfo:
+		logger.SetPrefix("☀️  ")
+	case SeverityWarning:
+		logger.SetPrefix("😐 ")
+	default:
+		logger.SetPrefix("😵 ")
+	}
+	logger.Println(diagnostic.String())
+	logger.SetPrefix(prefix)
+}
+
+// ReportDiagnostic adds a diagnostic to the build diagnostics or logs it when
+// the build does not collect diagnostics.
+func ReportDiagnostic(ctx context.Context, diagnostic Diagnostic) {
+	if diagnostics, ok := ctx.Value(builder_context.DiagnosticsContextKey).(*Diagnostics); ok {
+		diagnostics.Report(diagnostic)
+	} else if logger, ok := ctx.Value(builder_context.LoggerContextKey).(*log.Logger); ok {
+		logDiagnostic(logger, diagnostic)
+	}
+}
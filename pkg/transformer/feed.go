@@ -0,0 +1,513 @@
+package transformer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gostatic/pkg/markup"
+)
+
+const (
+	defaultFeedLimit int    = 20
+	atomNamespace    string = "http://www.w3.org/2005/Atom"
+	jsonFeedVersion  string = "https://jsonfeed.org/version/1.1"
+)
+
+var (
+	defaultFeedFormats = []string{"atom"}
+	feedExtensions     = map[string]string{"atom": ".atom", "rss": ".rss", "json": ".json"}
+	// attributes holding urls that are made absolute in feed content
+	feedURLAttributes = []string{"href", "src", "poster", "srcset"}
+	// the content of a page is the first of these that selects a node
+	defaultFeedContent = []string{"//article", "//main", "/html/body"}
+)
+
+// FeedSelectConfig holds the XPath expressions finding the title, date,
+// summary and content of a page. Frontmatter values are used before them.
+type FeedSelectConfig struct {
+	Title   string `yaml:"title"`
+	Date    string `yaml:"date"`
+	Summary string `yaml:"summary"`
+	Content string `yaml:"content"`
+}
+
+// FeedConfig is the configuration of a feed. Pages is a glob pattern of the
+// pages in the feed, relative to the output root. The feed is written to
+// output plus the extension of each format, with tags feeds of the pages of
+// each tag are written to a tags directory next to it. The title, by default
+// the name of the feed, is the author of Atom feeds and the description of
+// RSS feeds when those are not set.
+type FeedConfig struct {
+	Title       string           `yaml:"title"`
+	Description string           `yaml:"description"`
+	Author      string           `yaml:"author"`
+	Pages       string           `yaml:"pages"`
+	Output      string           `yaml:"output"`
+	Formats     []string         `yaml:"formats"`
+	Limit       int              `yaml:"limit"`
+	Tags        bool             `yaml:"tags"`
+	Select      FeedSelectConfig `yaml:"select"`
+}
+
+type feedItem struct {
+	url     string
+	title   string
+	date    time.Time
+	summary string
+	content string
+	tags    []string
+}
+
+type feed struct {
+	config  FeedConfig
+	title   string
+	url     string
+	home    string
+	updated time.Time
+	items   []feedItem
+}
+
+func parseDate(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// selectText returns the text of the first node an XPath expression selects.
+func selectText(xpath *markup.XPathContext, expression string) string {
+	if expression == "" {
+		return ""
+	}
+	result := xpath.Eval(expression)
+	if result == nil {
+		return ""
+	}
+	defer result.Free()
+	for _, node := range result.Results() {
+		return strings.Join(strings.Fields(node.GetContent()), " ")
+	}
+	return ""
+}
+
+// absoluteURLs makes the local urls inside node absolute against base.
+func absoluteURLs(xpath *markup.XPathContext, node *markup.Node, base *url.URL) {
+	for _, name := range feedURLAttributes {
+		elements := xpath.Eval(node.Path() + "//*[@" + name + "]")
+		if elements == nil {
+			continue
+		}
+		for _, element := range elements.Results() {
+			value := element.GetAttribute(name)
+			if name == "srcset" {
+				candidates := strings.Split(value, ",")
+				for i, candidate := range candidates {
+					fields := strings.Fields(candidate)
+					if len(fields) > 0 && isLocalURL(fields[0]) {
+						if ref, err := url.Parse(fields[0]); err == nil {
+							fields[0] = base.ResolveReference(ref).String()
+						}
+					}
+					candidates[i] = strings.Join(fields, " ")
+				}
+				element.SetAttribute(name, strings.Join(candidates, ", "))
+			} else if isLocalURL(value) {
+				if ref, err := url.Parse(value); err == nil {
+					element.SetAttribute(name, base.ResolveReference(ref).String())
+				}
+			}
+		}
+		elements.Free()
+	}
+}
+
+// selectContent returns the HTML inside the first node an XPath expression
+// selects, with absolute urls and without meta elements.
+func selectContent(xpath *markup.XPathContext, expressions []string, base *url.URL) (string, error) {
+	for _, expression := range expressions {
+		result := xpath.Eval(expression)
+		if result == nil {
+			return "", errors.New(fmt.Sprintf("invalid feed content selector: %s", expression))
+		}
+		nodes := result.Results()
+		if len(nodes) == 0 {
+			result.Free()
+			continue
+		}
+		defer result.Free()
+
+		node := nodes[0]
+		absoluteURLs(xpath, node, base)
+		if elements := xpath.Eval(node.Path() + "//meta"); elements != nil {
+			for _, meta := range elements.Results() {
+				meta.Unlink()
+				meta.Free()
+			}
+			elements.Free()
+		}
+		var buffer bytes.Buffer
+		serializer := markup.NewHTML5Serializer(bufio.NewWriter(&buffer))
+		if err := serializer.SerializeChildren(node); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buffer.String()), nil
+	}
+	return "", nil
+}
+
+func readFeedItem(entry ManifestEntry, pageURL string, config FeedSelectConfig) (feedItem, error) {
+	item := feedItem{url: pageURL, tags: entry.Meta.Values("tags")}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return item, err
+	}
+
+	document, err := readOutputDocument(entry.OutPath)
+	if err != nil {
+		return item, err
+	}
+	defer document.Free()
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	if item.title = entry.Meta.String("title"); item.title == "" {
+		item.title = selectText(xpath, config.Title)
+	}
+	if item.summary = entry.Meta.String("summary"); item.summary == "" {
+		if item.summary = entry.Meta.String("description"); item.summary == "" {
+			item.summary = selectText(xpath, config.Summary)
+		}
+	}
+	date, ok := parseDate(entry.Meta.String("date"))
+	if !ok {
+		if date, ok = parseDate(selectText(xpath, config.Date)); !ok {
+			date, _ = parseDate(pageDate(entry))
+		}
+	}
+	item.date = date
+	if len(item.tags) == 0 {
+		for _, tag := range strings.Split(selectText(xpath, "/html/head/meta[@name='tags']/@content"), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				item.tags = append(item.tags, tag)
+			}
+		}
+	}
+	contents := defaultFeedContent
+	if config.Content != "" {
+		contents = []string{config.Content}
+	}
+	item.content, err = selectContent(xpath, contents, base)
+	return item, err
+}
+
+func (f *feed) writeAtom(ctx context.Context, outPath string) error {
+	document := markup.NewDoc("1.0")
+	defer document.Free()
+	root := document.NewNode(nil, "feed", "")
+	root.SetAttribute("xmlns", atomNamespace)
+	document.SetRoot(root)
+
+	addTextChild(document, root, "title", f.title)
+	if f.config.Description != "" {
+		addTextChild(document, root, "subtitle", f.config.Description)
+	}
+	addTextChild(document, root, "id", f.url)
+	addTextChild(document, root, "updated", f.updated.Format(time.RFC3339))
+	self := root.NewChild(nil, "link", "")
+	self.SetAttribute("rel", "self")
+	self.SetAttribute("href", f.url)
+	root.NewChild(nil, "link", "").SetAttribute("href", f.home)
+	// atom feeds need an author, the feed title stands in for a missing one
+	author := f.config.Author
+	if author == "" {
+		author = f.config.Title
+	}
+	addTextChild(document, root.NewChild(nil, "author", ""), "name", author)
+
+	for _, item := range f.items {
+		entry := root.NewChild(nil, "entry", "")
+		addTextChild(document, entry, "title", item.title)
+		addTextChild(document, entry, "id", item.url)
+		entry.NewChild(nil, "link", "").SetAttribute("href", item.url)
+		addTextChild(document, entry, "updated", item.date.Format(time.RFC3339))
+		if item.summary != "" {
+			addTextChild(document, entry, "summary", item.summary)
+		}
+		if item.content != "" {
+			addTextChild(document, entry, "content", item.content).SetAttribute("type", "html")
+		}
+		for _, tag := range item.tags {
+			entry.NewChild(nil, "category", "").SetAttribute("term", tag)
+		}
+	}
+	return writeXMLDocument(ctx, document, outPath)
+}
+
+func (f *feed) writeRSS(ctx context.Context, outPath string) error {
+	document := markup.NewDoc("1.0")
+	defer document.Free()
+	root := document.NewNode(nil, "rss", "")
+	root.SetAttribute("version", "2.0")
+	document.SetRoot(root)
+
+	channel := root.NewChild(nil, "channel", "")
+	addTextChild(document, channel, "title", f.title)
+	addTextChild(document, channel, "link", f.home)
+	// rss channels need a description, the title stands in for a missing one
+	description := f.config.Description
+	if description == "" {
+		description = f.title
+	}
+	addTextChild(document, channel, "description", description)
+	addTextChild(document, channel, "lastBuildDate", f.updated.Format(time.RFC1123Z))
+
+	for _, item := range f.items {
+		node := channel.NewChild(nil, "item", "")
+		addTextChild(document, node, "title", item.title)
+		addTextChild(document, node, "link", item.url)
+		addTextChild(document, node, "guid", item.url).SetAttribute("isPermaLink", "true")
+		addTextChild(document, node, "pubDate", item.date.Format(time.RFC1123Z))
+		if item.content != "" {
+			addTextChild(document, node, "description", item.content)
+		} else if item.summary != "" {
+			addTextChild(document, node, "description", item.summary)
+		}
+		for _, tag := range item.tags {
+			addTextChild(document, node, "category", tag)
+		}
+	}
+	return writeXMLDocument(ctx, document, outPath)
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Authors     []jsonAuthor   `json:"authors,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	Summary       string   `json:"summary,omitempty"`
+	DatePublished string   `json:"date_published"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+func (f *feed) writeJSON(ctx context.Context, outPath string) error {
+	out := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       f.title,
+		HomePageURL: f.home,
+		FeedURL:     f.url,
+		Description: f.config.Description,
+		Items:       []jsonFeedItem{},
+	}
+	if f.config.Author != "" {
+		out.Authors = []jsonAuthor{{f.config.Author}}
+	}
+	for _, item := range f.items {
+		out.Items = append(out.Items, jsonFeedItem{
+			ID:            item.url,
+			URL:           item.url,
+			Title:         item.title,
+			ContentHTML:   item.content,
+			Summary:       item.summary,
+			DatePublished: item.date.Format(time.RFC3339),
+			Tags:          item.tags,
+		})
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+	if manifest, err := buildManifest(ctx); err == nil {
+		manifest.Add("", outPath)
+	}
+	return nil
+}
+
+// write writes the feed in every format to output plus the format extension.
+func (f *feed) write(ctx context.Context, baseURL string, outRoot string, output string) error {
+	if len(f.items) > f.config.Limit {
+		f.items = f.items[:f.config.Limit]
+	}
+	for _, item := range f.items {
+		if item.date.After(f.updated) {
+			f.updated = item.date
+		}
+	}
+	for _, format := range f.config.Formats {
+		outPath := filepath.Join(outRoot, output+feedExtensions[format])
+		feedURL, err := pageURL(baseURL, outRoot, outPath)
+		if err != nil {
+			return err
+		}
+		f.url = feedURL
+		switch format {
+		case "atom":
+			err = f.writeAtom(ctx, outPath)
+		case "rss":
+			err = f.writeRSS(ctx, outPath)
+		case "json":
+			err = f.writeJSON(ctx, outPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildFeed(ctx context.Context, name string, config FeedConfig, baseURL string) error {
+	manifest, err := buildManifest(ctx)
+	if err != nil {
+		return err
+	}
+	if config.Pages == "" {
+		return errors.New(fmt.Sprintf("feed %s has no pages", name))
+	}
+	if config.Output == "" {
+		config.Output = name
+	}
+	if config.Formats == nil {
+		config.Formats = defaultFeedFormats
+	}
+	for _, format := range config.Formats {
+		if _, ok := feedExtensions[format]; !ok {
+			return errors.New(fmt.Sprintf("unknown feed format: %s", format))
+		}
+	}
+	if config.Limit <= 0 {
+		config.Limit = defaultFeedLimit
+	}
+	if config.Title == "" {
+		config.Title = name
+	}
+	if config.Select.Title == "" {
+		config.Select.Title = "/html/head/title"
+	}
+	if config.Select.Date == "" {
+		config.Select.Date = "/html/head/meta[@name='date']/@content"
+	}
+	if config.Select.Summary == "" {
+		config.Select.Summary = "/html/head/meta[@name='description']/@content"
+	}
+
+	outRoot := outputRoot(ctx)
+	home, err := pageURL(baseURL, outRoot, filepath.Join(outRoot, filepath.Dir(config.Pages), "index.html"))
+	if err != nil {
+		return err
+	}
+
+	items := []feedItem{}
+	for _, entry := range manifest.Entries() {
+		relPath, err := filepath.Rel(outRoot, entry.OutPath)
+		if err != nil {
+			continue
+		}
+		if ok, _ := filepath.Match(config.Pages, filepath.ToSlash(relPath)); !ok || filepath.Ext(relPath) != ".html" {
+			continue
+		}
+		itemURL, err := pageURL(baseURL, outRoot, entry.OutPath)
+		if err != nil {
+			return err
+		}
+		item, err := readFeedItem(entry, itemURL, config.Select)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].date.After(items[j].date) })
+
+	f := &feed{config: config, title: config.Title, home: home, items: items}
+	if err := f.write(ctx, baseURL, outRoot, config.Output); err != nil {
+		return err
+	}
+	if !config.Tags {
+		return nil
+	}
+
+	tagged := map[string][]feedItem{}
+	titles := map[string]string{}
+	for _, item := range items {
+		for _, tag := range item.tags {
+			slug := slugify(tag)
+			tagged[slug] = append(tagged[slug], item)
+			titles[slug] = tag
+		}
+	}
+	for slug, tagItems := range tagged {
+		output := filepath.Join(filepath.Dir(config.Output), "tags", slug, filepath.Base(config.Output))
+		f := &feed{config: config, title: fmt.Sprintf("%s: %s", config.Title, titles[slug]), home: home, items: tagItems}
+		if err := f.write(ctx, baseURL, outRoot, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TransformFeed writes the feeds of the site configuration. It is a step
+// listed under after. The optional argument is the name of one feed.
+func TransformFeed(ctx context.Context, args []string) (context.Context, Status, error) {
+	config := siteConfig(ctx)
+	if config.URL == "" {
+		return ctx, Continue, errors.New("feeds need the site url")
+	}
+
+	names := []string{}
+	if len(args) > 0 && args[0] != "" {
+		if _, ok := config.Feeds[args[0]]; !ok {
+			return ctx, Continue, errors.New(fmt.Sprintf("unknown feed: %s", args[0]))
+		}
+		names = append(names, args[0])
+	} else {
+		for name := range config.Feeds {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		if err := buildFeed(ctx, name, config.Feeds[name], config.URL); err != nil {
+			return ctx, Continue, err
+		}
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("feed", TransformFeed)
+}
@@ -0,0 +1,325 @@
+package transformer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+const (
+	defaultFingerprintLength int    = 8
+	defaultFingerprintMap    string = "assets.json"
+)
+
+var (
+	defaultFingerprintInclude = []string{
+		"*.css", "*.js", "*.mjs",
+		"*.png", "*.jpg", "*.jpeg", "*.gif", "*.svg", "*.webp", "*.avif", "*.ico",
+		"*.woff", "*.woff2", "*.ttf", "*.otf", "*.eot",
+	}
+	cssURLPattern    = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+	cssImportPattern = regexp.MustCompile(`@import\s+(['"])([^'"]+)(['"])`)
+)
+
+type FingerprintConfig struct {
+	Length  int      `yaml:"length"`
+	Include []string `yaml:"include"`
+	Map     string   `yaml:"map"`
+}
+
+func (c FingerprintConfig) includes(path string) bool {
+	patterns := c.Include
+	if len(patterns) == 0 {
+		patterns = defaultFingerprintInclude
+	}
+	name := filepath.Base(path)
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func fingerprintName(path string, data []byte, length int) string {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	if length > 0 && length < len(digest) {
+		digest = digest[:length]
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + digest + ext
+}
+
+// rewriteReference swaps the file name of a local reference for its fingerprinted name.
+func rewriteReference(ref string, basePath string, outRoot string, assets map[string]string) string {
+	target, ok := resolveOutputURL(ref, basePath, outRoot)
+	if !ok {
+		return ref
+	}
+	hashedPath, ok := assets[target]
+	if !ok {
+		return ref
+	}
+	refPath, suffix := splitURL(ref)
+	dir := refPath[:strings.LastIndex(refPath, "/")+1]
+	return dir + filepath.Base(hashedPath) + suffix
+}
+
+func rewriteSrcset(srcset string, basePath string, outRoot string, assets map[string]string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = rewriteReference(fields[0], basePath, outRoot, assets)
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+func rewriteCSS(css string, basePath string, outRoot string, assets map[string]string) string {
+	rewrite := func(pattern *regexp.Regexp, css string) string {
+		return pattern.ReplaceAllStringFunc(css, func(match string) string {
+			groups := pattern.FindStringSubmatch(match)
+			ref := strings.TrimSpace(groups[2])
+			if newRef := rewriteReference(ref, basePath, outRoot, assets); newRef != ref {
+				return strings.Replace(match, ref, newRef, 1)
+			}
+			return match
+		})
+	}
+	return rewrite(cssImportPattern, rewrite(cssURLPattern, css))
+}
+
+func rewriteDocumentReferences(document *markup.Document, docPath string, outRoot string, assets map[string]string) {
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	for _, name := range []string{"href", "src", "poster"} {
+		if elements := xpath.Eval("//*[@" + name + "]"); elements != nil {
+			for _, node := range elements.Results() {
+				ref := node.GetAttribute(name)
+				if newRef := rewriteReference(ref, docPath, outRoot, assets); newRef != ref {
+					node.SetAttribute(name, newRef)
+				}
+			}
+			elements.Free()
+		}
+	}
+
+	if elements := xpath.Eval("//*[@srcset]"); elements != nil {
+		for _, node := range elements.Results() {
+			node.SetAttribute("srcset", rewriteSrcset(node.GetAttribute("srcset"), docPath, outRoot, assets))
+		}
+		elements.Free()
+	}
+
+	if elements := xpath.Eval("//*[@style]"); elements != nil {
+		for _, node := range elements.Results() {
+			style := node.GetAttribute("style")
+			if newStyle := rewriteCSS(style, docPath, outRoot, assets); newStyle != style {
+				node.SetAttribute("style", newStyle)
+			}
+		}
+		elements.Free()
+	}
+
+	if elements := xpath.Eval("//style"); elements != nil {
+		for _, node := range elements.Results() {
+			css := node.GetContent()
+			if newCSS := rewriteCSS(css, docPath, outRoot, assets); newCSS != css {
+				setTextContent(document, node, newCSS)
+			}
+		}
+		elements.Free()
+	}
+}
+
+// cssReferences returns the local files a stylesheet refers to with url() or
+// @import.
+func cssReferences(css string, basePath string, outRoot string) []string {
+	references := []string{}
+	for _, pattern := range []*regexp.Regexp{cssURLPattern, cssImportPattern} {
+		for _, groups := range pattern.FindAllStringSubmatch(css, -1) {
+			if target, ok := resolveOutputURL(strings.TrimSpace(groups[2]), basePath, outRoot); ok {
+				references = append(references, target)
+			}
+		}
+	}
+	return references
+}
+
+// orderStylesheets puts the stylesheets after the other assets and each
+// stylesheet after the stylesheets it refers to, so that its references
+// already point to hashed files when it is hashed. Stylesheets referring to
+// each other in a cycle are kept in manifest order.
+func orderStylesheets(assetPaths []string, outRoot string) ([]string, error) {
+	ordered := []string{}
+	stylesheets := make(map[string]bool)
+	for _, assetPath := range assetPaths {
+		if filepath.Ext(assetPath) == ".css" {
+			stylesheets[assetPath] = true
+		} else {
+			ordered = append(ordered, assetPath)
+		}
+	}
+
+	visited := make(map[string]bool)
+	var visit func(assetPath string) error
+	visit = func(assetPath string) error {
+		if visited[assetPath] {
+			return nil
+		}
+		visited[assetPath] = true
+		data, err := os.ReadFile(assetPath)
+		if err != nil {
+			return err
+		}
+		for _, reference := range cssReferences(string(data), assetPath, outRoot) {
+			if stylesheets[reference] {
+				if err := visit(reference); err != nil {
+					return err
+				}
+			}
+		}
+		ordered = append(ordered, assetPath)
+		return nil
+	}
+	for _, assetPath := range assetPaths {
+		if stylesheets[assetPath] {
+			if err := visit(assetPath); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ordered, nil
+}
+
+func readAssetMap(mapPath string) map[string]string {
+	assetMap := map[string]string{}
+	if data, err := os.ReadFile(mapPath); err == nil {
+		_ = json.Unmarshal(data, &assetMap)
+	}
+	return assetMap
+}
+
+func writeAssetMap(mapPath string, assetMap map[string]string) error {
+	data, err := json.MarshalIndent(assetMap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(mapPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(mapPath, append(data, '\n'), 0644)
+}
+
+func TransformFingerprint(ctx context.Context, args []string) (context.Context, Status, error) {
+	manifest, err := buildManifest(ctx)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	rootPath, ok := ctx.Value(builder_context.RootPathContextKey).(string)
+	if !ok {
+		return ctx, Continue, errors.New("missing root path")
+	}
+
+	config := siteConfig(ctx).Fingerprint
+	length := config.Length
+	if length <= 0 {
+		length = defaultFingerprintLength
+	}
+	outRoot := outputRoot(ctx)
+	mapPath := filepath.Join(outRoot, defaultFingerprintMap)
+	if config.Map != "" {
+		mapPath = filepath.Join(rootPath, config.Map)
+	}
+
+	assetPaths := []string{}
+	for _, entry := range manifest.Entries() {
+		if ext := filepath.Ext(entry.OutPath); ext != ".html" && ext != ".xml" && config.includes(entry.OutPath) {
+			assetPaths = append(assetPaths, entry.OutPath)
+		}
+	}
+	assetPaths, err = orderStylesheets(assetPaths, outRoot)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	assets := make(map[string]string)
+	for _, assetPath := range assetPaths {
+		data, err := os.ReadFile(assetPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		if filepath.Ext(assetPath) == ".css" {
+			css := string(data)
+			if newCSS := rewriteCSS(css, assetPath, outRoot, assets); newCSS != css {
+				data = []byte(newCSS)
+				if err := os.WriteFile(assetPath, data, 0644); err != nil {
+					return ctx, Continue, err
+				}
+			}
+		}
+		hashedPath := fingerprintName(assetPath, data, length)
+		if err := os.Rename(assetPath, hashedPath); err != nil {
+			return ctx, Continue, err
+		}
+		manifest.Rename(assetPath, hashedPath)
+		assets[assetPath] = hashedPath
+	}
+
+	for _, docPath := range manifest.Outputs(".html") {
+		document, err := readOutputDocument(docPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		rewriteDocumentReferences(document, docPath, outRoot, assets)
+		err = writeOutputDocument(ctx, document, docPath)
+		document.Free()
+		if err != nil {
+			return ctx, Continue, err
+		}
+	}
+
+	assetMap := make(map[string]string)
+	for assetPath, hashedPath := range assets {
+		relPath, err := filepath.Rel(outRoot, assetPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		relHashedPath, err := filepath.Rel(outRoot, hashedPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		assetMap[filepath.ToSlash(relPath)] = filepath.ToSlash(relHashedPath)
+	}
+
+	// remove files fingerprinted by a previous build that are no longer referenced
+	for relPath, relHashedPath := range readAssetMap(mapPath) {
+		if assetMap[relPath] != relHashedPath {
+			_ = os.Remove(filepath.Join(outRoot, filepath.FromSlash(relHashedPath)))
+		}
+	}
+
+	if err := writeAssetMap(mapPath, assetMap); err != nil {
+		return ctx, Continue, err
+	}
+
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("fingerprint", TransformFingerprint)
+}
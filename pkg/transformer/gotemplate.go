@@ -0,0 +1,200 @@
+package transformer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+// GoTemplateConfig is the configuration of the gotemplate transformation.
+// Partials is a glob pattern, relative to the root, of template files parsed
+// with every layout so that it can use the templates they define.
+type GoTemplateConfig struct {
+	Partials string `yaml:"partials"`
+}
+
+// goTemplateData is the data a Go template is executed with. Document, Head
+// and Body are the serialized document and the content of its head and body
+// elements. Documents without a body have the content of their root element
+// as body.
+type goTemplateData struct {
+	Document template.HTML
+	Head     template.HTML
+	Body     template.HTML
+	Title    string
+	Meta     Metadata
+	Config   *Config
+	InPath   string
+	OutPath  string
+	RootPath string
+}
+
+// goTemplateFuncs are placeholders of the functions bound to the document
+// when a template is executed.
+var goTemplateFuncs = template.FuncMap{
+	"xpath": func(expression string) ([]string, error) { return nil, nil },
+}
+
+func serializeHTML(document *markup.Document, node *markup.Node) (template.HTML, error) {
+	var buffer bytes.Buffer
+	serializer := markup.NewHTML5Serializer(bufio.NewWriter(&buffer))
+	var err error
+	if node == nil {
+		err = serializer.Serialize(document)
+	} else {
+		err = serializer.SerializeChildren(node)
+	}
+	return template.HTML(strings.TrimSpace(buffer.String())), err
+}
+
+func firstNode(xpath *markup.XPathContext, expression string) *markup.Node {
+	result := xpath.Eval(expression)
+	if result == nil {
+		return nil
+	}
+	defer result.Free()
+	if nodes := result.Results(); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+// loadGoTemplate parses a layout and the partials once per build.
+func loadGoTemplate(ctx context.Context, path string, partials string) (*template.Template, error) {
+	value, err := buildState(ctx).Load("gotemplate:"+path, func() (interface{}, error) {
+		tmpl, err := template.New(filepath.Base(path)).Funcs(goTemplateFuncs).ParseFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		if partials != "" {
+			matches, err := filepath.Glob(partials)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) > 0 {
+				if tmpl, err = tmpl.ParseFiles(matches...); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return tmpl, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*template.Template), nil
+}
+
+// TransformGoTemplate renders a Go html/template layout with the document,
+// its metadata and the site config as data. The result is parsed into a new
+// document. The argument is the template file relative to the root, or
+// layout for the layout named in the metadata.
+func TransformGoTemplate(ctx context.Context, args []string) (context.Context, Status, error) {
+	if len(args) < 1 || args[0] == "" {
+		return ctx, Continue, errors.New("missing argument for gotemplate transform")
+	}
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document to gotemplate transform")
+	}
+	rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	outPath, _ := ctx.Value(builder_context.OutPathContextKey).(string)
+
+	meta := documentMetadata(ctx)
+	filename := args[0]
+	if filename == "layout" {
+		if filename = meta.String("layout"); filename == "" {
+			return ctx, Continue, errors.New("missing layout in document metadata")
+		}
+	}
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(rootPath, filename)
+	}
+
+	config := siteConfig(ctx)
+	partials := config.GoTemplate.Partials
+	if partials != "" && !filepath.IsAbs(partials) {
+		partials = filepath.Join(rootPath, partials)
+	}
+	layout, err := loadGoTemplate(ctx, filename, partials)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	data := goTemplateData{
+		Meta:     meta,
+		Config:   config,
+		InPath:   inPath,
+		OutPath:  outPath,
+		RootPath: rootPath,
+	}
+	if data.Document, err = serializeHTML(document, nil); err != nil {
+		return ctx, Continue, err
+	}
+	if head := firstNode(xpath, "/html/head"); head != nil {
+		if data.Head, err = serializeHTML(document, head); err != nil {
+			return ctx, Continue, err
+		}
+	}
+	body := firstNode(xpath, "/html/body")
+	if body == nil {
+		body = document.Root()
+	}
+	if body != nil {
+		if data.Body, err = serializeHTML(document, body); err != nil {
+			return ctx, Continue, err
+		}
+	}
+	if data.Title = meta.String("title"); data.Title == "" {
+		if title := firstNode(xpath, "/html/head/title"); title != nil {
+			data.Title = strings.TrimSpace(title.GetContent())
+		}
+	}
+
+	tmpl, err := layout.Clone()
+	if err != nil {
+		return ctx, Continue, err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"xpath": func(expression string) ([]string, error) {
+			result := xpath.Eval(expression)
+			if result == nil {
+				return nil, errors.New(fmt.Sprintf("invalid xpath expression: %s", expression))
+			}
+			defer result.Free()
+			values := []string{}
+			for _, node := range result.Results() {
+				values = append(values, node.GetContent())
+			}
+			return values, nil
+		},
+	})
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return ctx, Continue, err
+	}
+
+	rendered := markup.ReadHTMLMemory(buffer.Bytes(), outputParseOptions)
+	if rendered == nil {
+		return ctx, Continue, errors.New(fmt.Sprintf("unable to parse result of template %s", args[0]))
+	}
+	document.Free()
+	return context.WithValue(ctx, builder_context.DocumentContextKey, rendered), Continue, nil
+}
+
+func init() {
+	Registry.Register("gotemplate", TransformGoTemplate)
+}
@@ -0,0 +1,263 @@
+package transformer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markdown"
+	"gostatic/pkg/markup"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// the selector matches XHTML code blocks of XML documents too
+const defaultHighlightSelector string = "//*[local-name()='pre']/*[local-name()='code'][contains(concat(' ', @class), ' language-') or contains(concat(' ', @class), ' lang-') or @data-lang]"
+
+// HighlightConfig is the configuration of the highlight transformation.
+// Selector finds the code elements to highlight, their language is read from
+// a language-* or lang-* class or a data-lang attribute. With classes the
+// stylesheet of the style is written to css, relative to the project root.
+type HighlightConfig struct {
+	Selector    string `yaml:"selector"`
+	Style       string `yaml:"style"`
+	LineNumbers bool   `yaml:"lineNumbers"`
+	Classes     bool   `yaml:"classes"`
+	CSS         string `yaml:"css"`
+}
+
+func codeLanguage(node *markup.Node) string {
+	if lang := node.GetAttribute("data-lang"); lang != "" {
+		return lang
+	}
+	for _, class := range strings.Fields(node.GetAttribute("class")) {
+		if strings.HasPrefix(class, "language-") {
+			return strings.TrimPrefix(class, "language-")
+		}
+		if strings.HasPrefix(class, "lang-") {
+			return strings.TrimPrefix(class, "lang-")
+		}
+	}
+	return ""
+}
+
+type highlighter struct {
+	formatter *chromahtml.Formatter
+	style     *chroma.Style
+}
+
+func newHighlighter(config HighlightConfig) (*highlighter, error) {
+	name := config.Style
+	if name == "" {
+		name = defaultMarkdownStyle
+	}
+	style, ok := styles.Registry[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown highlight style: %s", name))
+	}
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(config.Classes),
+		chromahtml.WithLineNumbers(config.LineNumbers),
+	)
+	return &highlighter{formatter, style}, nil
+}
+
+// highlight replaces the pre element around code with the highlighted code.
+func (h *highlighter) highlight(document *markup.Document, code *markup.Node, lexer chroma.Lexer) error {
+	pre := code.Parent()
+	source := strings.TrimPrefix(code.GetContent(), "\n")
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, source)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if err := h.formatter.Format(&buffer, h.style, iterator); err != nil {
+		return err
+	}
+
+	container := document.NewNode(nil, "div", "")
+	pre.AddPrevSibling(container)
+	writer := markdown.NewTreeWriter(document, container)
+	_, err = writer.Write(buffer.Bytes())
+	writer.Terminate()
+	writer.Free()
+	if err != nil {
+		return err
+	}
+
+	if ns := pre.Namespace(); ns != nil {
+		setNamespace(container, ns)
+	}
+	if generated := firstElement(container, "pre"); generated != nil {
+		copyAttributes(pre, generated)
+	}
+
+	for child := container.FirstChildNode(); child != nil; child = container.FirstChildNode() {
+		child.Unlink()
+		container.AddPrevSibling(child)
+	}
+	container.Unlink()
+	container.Free()
+	pre.Unlink()
+	pre.Free()
+	return nil
+}
+
+// setNamespace puts the elements below node in a namespace, so that the
+// HTML chroma writes fits into XHTML documents.
+func setNamespace(node *markup.Node, ns *markup.Namespace) {
+	for _, child := range children(node) {
+		if child.Type() == markup.XML_ELEMENT_NODE {
+			child.SetNs(ns)
+			setNamespace(child, ns)
+		}
+	}
+}
+
+// firstElement returns the first element with a name below node in document
+// order.
+func firstElement(node *markup.Node, name string) *markup.Node {
+	for _, child := range children(node) {
+		if child.Type() != markup.XML_ELEMENT_NODE {
+			continue
+		}
+		if child.Name() == name {
+			return child
+		}
+		if element := firstElement(child, name); element != nil {
+			return element
+		}
+	}
+	return nil
+}
+
+// copyAttributes copies the attributes of the replaced pre element to the
+// generated one. Classes and styles are added to the ones chroma sets.
+func copyAttributes(from *markup.Node, to *markup.Node) {
+	for attr := from.Attributes(); attr != nil; attr = attr.Next() {
+		name := attr.Name()
+		value := from.GetAttribute(name)
+		if existing := to.GetAttribute(name); existing != "" {
+			switch name {
+			case "class":
+				value = existing + " " + value
+			case "style":
+				value = strings.TrimSuffix(existing, ";") + "; " + value
+			}
+		}
+		to.SetAttribute(name, value)
+	}
+}
+
+func (h *highlighter) writeCSS(ctx context.Context, cssPath string) error {
+	_, err := buildState(ctx).Load("highlight.css:"+cssPath, func() (interface{}, error) {
+		var buffer bytes.Buffer
+		if err := h.formatter.WriteCSS(&buffer, h.style); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(cssPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(cssPath, buffer.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		if manifest, ok := ctx.Value(builder_context.ManifestContextKey).(*Manifest); ok {
+			manifest.Add("", cssPath)
+		}
+		return cssPath, nil
+	})
+	return err
+}
+
+// TransformHighlight highlights code blocks of HTML documents with chroma.
+// The optional argument is the selector.
+func TransformHighlight(ctx context.Context, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document")
+	}
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+
+	config := siteConfig(ctx).Highlight
+	if len(args) > 0 && args[0] != "" {
+		config.Selector = strings.Join(args, ":")
+	}
+	if config.Selector == "" {
+		config.Selector = defaultHighlightSelector
+	}
+
+	h, err := newHighlighter(config)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	if config.Classes && config.CSS != "" {
+		rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+		if err := h.writeCSS(ctx, filepath.Join(rootPath, config.CSS)); err != nil {
+			return ctx, Continue, err
+		}
+	}
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	elements := xpath.Eval(config.Selector)
+	if elements == nil {
+		return ctx, Continue, errors.New(fmt.Sprintf("invalid highlight selector: %s", config.Selector))
+	}
+	defer elements.Free()
+
+	// a pre element is replaced once, with the first code element in it, and
+	// not at all inside a pre element that is replaced
+	codes := []*markup.Node{}
+	replaced := []string{}
+	for _, node := range elements.Results() {
+		parent := node.Parent()
+		if parent == nil || parent.Name() != "pre" {
+			continue
+		}
+		path := parent.Path()
+		nested := false
+		for _, other := range replaced {
+			if path == other || strings.HasPrefix(path, other+"/") {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			replaced = append(replaced, path)
+			codes = append(codes, node)
+		}
+	}
+
+	for _, node := range codes {
+		language := codeLanguage(node)
+		lexer := lexers.Get(language)
+		if lexer == nil {
+			ReportDiagnostic(ctx, Diagnostic{
+				Severity: SeverityWarning,
+				File:     inPath,
+				Element:  node.Path(),
+				Message:  fmt.Sprintf("unknown code language: %s", language),
+			})
+			continue
+		}
+		if err := h.highlight(document, node, lexer); err != nil {
+			return ctx, Continue, err
+		}
+	}
+
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("highlight", TransformHighlight)
+}
@@ -0,0 +1,449 @@
+package transformer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const defaultCatalogsDir string = "i18n"
+
+// I18nConfig is the configuration of translations. Catalogs is the directory
+// of the translation catalogs, <locale>.yaml or <locale>.po, relative to the
+// root. Default is the locale of the pages of sections that are not built
+// per locale and of x-default alternate links, the first locale by default.
+type I18nConfig struct {
+	Catalogs string         `yaml:"catalogs"`
+	Default  string         `yaml:"default"`
+	Locales  []LocaleConfig `yaml:"locales"`
+}
+
+// LocaleConfig is a locale sections can be built for. Prefix is the
+// directory of its pages in the output directory.
+type LocaleConfig struct {
+	Name   string `yaml:"name"`
+	Prefix string `yaml:"prefix"`
+}
+
+func (c I18nConfig) Locale(name string) (LocaleConfig, bool) {
+	for _, locale := range c.Locales {
+		if locale.Name == name {
+			return locale, true
+		}
+	}
+	return LocaleConfig{}, false
+}
+
+func (c I18nConfig) DefaultLocale() string {
+	if c.Default == "" && len(c.Locales) > 0 {
+		return c.Locales[0].Name
+	}
+	return c.Default
+}
+
+// Localization is the locale a section is built for. Outputs are the output
+// directories of the locales the section is built for.
+type Localization struct {
+	Locale  string
+	Locales []string
+	Outputs map[string]string
+}
+
+// Alternate returns the output file of a page of the section in another
+// locale.
+func (l *Localization) Alternate(outPath string, locale string) (string, error) {
+	relPath, err := filepath.Rel(l.Outputs[l.Locale], outPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Outputs[locale], relPath), nil
+}
+
+func documentLocalization(ctx context.Context) *Localization {
+	localization, _ := ctx.Value(builder_context.LocalizationContextKey).(*Localization)
+	return localization
+}
+
+// documentLocale is the locale the current file is built for, the default
+// locale for sections not built per locale.
+func documentLocale(ctx context.Context) string {
+	if localization := documentLocalization(ctx); localization != nil {
+		return localization.Locale
+	}
+	return siteConfig(ctx).I18n.DefaultLocale()
+}
+
+// Catalog maps message keys to the messages of a locale.
+type Catalog map[string]string
+
+// flatten adds the values of a YAML mapping, the keys of nested mappings
+// joined with dots.
+func (c Catalog) flatten(prefix string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			c.flatten(key, nested)
+		}
+	case nil:
+	default:
+		c[prefix] = fmt.Sprint(value)
+	}
+}
+
+func readYAMLCatalog(path string) (Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	catalog := Catalog{}
+	catalog.flatten("", values)
+	return catalog, nil
+}
+
+// readPOCatalog reads a gettext catalog. The first plural form is used for
+// messages with plurals, untranslated and fuzzy messages and the header are
+// skipped.
+func readPOCatalog(path string) (Catalog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	catalog := Catalog{}
+	var msgid, msgstr, field *string
+	id, str := "", ""
+	fuzzy := false
+	add := func() {
+		if msgid != nil && msgstr != nil && *msgid != "" && *msgstr != "" && !fuzzy {
+			catalog[*msgid] = *msgstr
+		}
+		msgid, msgstr, field = nil, nil, nil
+		fuzzy = false
+	}
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#,") {
+			// flags come before the message they belong to
+			if msgid != nil {
+				add()
+			}
+			for _, flag := range strings.Split(strings.TrimPrefix(line, "#,"), ",") {
+				if strings.TrimSpace(flag) == "fuzzy" {
+					fuzzy = true
+				}
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, quoted, _ := strings.Cut(line, " ")
+		if strings.HasPrefix(line, `"`) {
+			keyword, quoted = "", line
+		}
+		var value string
+		if value, err = strconv.Unquote(strings.TrimSpace(quoted)); err != nil {
+			return nil, errors.New(fmt.Sprintf("%s:%d: invalid string", path, number))
+		}
+		switch keyword {
+		case "":
+			if field != nil {
+				*field += value
+			}
+		case "msgctxt":
+			if msgid != nil {
+				add()
+			}
+			field = nil
+		case "msgid":
+			if msgid != nil {
+				add()
+			}
+			id = value
+			msgid, field = &id, &id
+		case "msgid_plural":
+			field = nil
+		case "msgstr", "msgstr[0]":
+			str = value
+			msgstr, field = &str, &str
+		default:
+			field = nil
+		}
+	}
+	add()
+	return catalog, scanner.Err()
+}
+
+// loadCatalog returns the catalog of a locale and its path. Catalogs are read
+// once per build.
+func loadCatalog(ctx context.Context, locale string) (Catalog, string, error) {
+	rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+	dir := siteConfig(ctx).I18n.Catalogs
+	if dir == "" {
+		dir = defaultCatalogsDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(rootPath, dir)
+	}
+
+	type loaded struct {
+		catalog Catalog
+		path    string
+	}
+	value, err := buildState(ctx).Load("catalog:"+filepath.Join(dir, locale), func() (interface{}, error) {
+		for _, ext := range []string{".yaml", ".yml", ".po"} {
+			path := filepath.Join(dir, locale+ext)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			read := readYAMLCatalog
+			if ext == ".po" {
+				read = readPOCatalog
+			}
+			catalog, err := read(path)
+			if err != nil {
+				return nil, err
+			}
+			return loaded{catalog, path}, nil
+		}
+		return nil, errors.New(fmt.Sprintf("missing catalog for locale %s in %s", locale, dir))
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return value.(loaded).catalog, value.(loaded).path, nil
+}
+
+// translator looks up messages in the catalog of a locale, then in the
+// catalog of the default locale. Missing messages are reported.
+type translator struct {
+	ctx      context.Context
+	locale   string
+	catalog  Catalog
+	fallback Catalog
+}
+
+func newTranslator(ctx context.Context, locale string) (*translator, error) {
+	catalog, path, err := loadCatalog(ctx, locale)
+	if err != nil {
+		return nil, err
+	}
+	addDependency(ctx, path)
+	t := &translator{ctx: ctx, locale: locale, catalog: catalog}
+	if defaultLocale := siteConfig(ctx).I18n.DefaultLocale(); defaultLocale != "" && defaultLocale != locale {
+		if t.fallback, path, err = loadCatalog(ctx, defaultLocale); err != nil {
+			return nil, err
+		}
+		addDependency(ctx, path)
+	}
+	return t, nil
+}
+
+func (t *translator) Translate(key string) (string, bool) {
+	if message, ok := t.catalog[key]; ok {
+		return message, true
+	}
+	inPath, _ := t.ctx.Value(builder_context.InPathContextKey).(string)
+	ReportDiagnostic(t.ctx, Diagnostic{
+		Severity: SeverityWarning,
+		File:     inPath,
+		Message:  fmt.Sprintf("missing %s translation: %s", t.locale, key),
+	})
+	message, ok := t.fallback[key]
+	return message, ok
+}
+
+// lazyTranslator reads the catalogs when the first message is looked up, so
+// that stylesheets not translating anything work without catalogs.
+type lazyTranslator struct {
+	ctx        context.Context
+	locale     string
+	translator *translator
+	err        error
+}
+
+func (l *lazyTranslator) Translate(key string) (string, bool) {
+	if l.translator == nil && l.err == nil {
+		if l.translator, l.err = newTranslator(l.ctx, l.locale); l.err != nil {
+			inPath, _ := l.ctx.Value(builder_context.InPathContextKey).(string)
+			ReportDiagnostic(l.ctx, Diagnostic{
+				Severity: SeverityError,
+				File:     inPath,
+				Message:  l.err.Error(),
+			})
+		}
+	}
+	if l.err != nil {
+		return "", false
+	}
+	return l.translator.Translate(key)
+}
+
+func evalNodes(xpath *markup.XPathContext, expression string) []*markup.Node {
+	result := xpath.Eval(expression)
+	if result == nil {
+		return nil
+	}
+	defer result.Free()
+	return result.Results()
+}
+
+// translateDocument replaces the content of elements with a data-i18n
+// attribute and the value of the attributes named by data-i18n-<name>
+// attributes with the messages of the keys they hold. <t key="..."> elements
+// are replaced by the message. Without a message the content is kept.
+// Elements are handled in reverse document order, so nested ones are done
+// before their ancestors free them.
+func translateDocument(document *markup.Document, xpath *markup.XPathContext, t *translator) {
+	nodes := evalNodes(xpath, "//*[@data-i18n]")
+	for i := len(nodes) - 1; i >= 0; i-- {
+		node := nodes[i]
+		if message, ok := t.Translate(node.GetAttribute("data-i18n")); ok {
+			setTextContent(document, node, message)
+		}
+		node.UnsetAttribute("data-i18n")
+	}
+
+	for _, node := range evalNodes(xpath, "//*[@*[starts-with(name(), 'data-i18n-')]]") {
+		names := []string{}
+		for attr := node.Attributes(); attr != nil; attr = attr.Next() {
+			if strings.HasPrefix(attr.Name(), "data-i18n-") {
+				names = append(names, attr.Name())
+			}
+		}
+		for _, name := range names {
+			if message, ok := t.Translate(node.GetAttribute(name)); ok {
+				node.SetAttribute(strings.TrimPrefix(name, "data-i18n-"), message)
+			}
+			node.UnsetAttribute(name)
+		}
+	}
+
+	nodes = evalNodes(xpath, "//t[@key]")
+	for i := len(nodes) - 1; i >= 0; i-- {
+		node := nodes[i]
+		if message, ok := t.Translate(node.GetAttribute("key")); ok {
+			node.AddPrevSibling(document.NewText(message).Node)
+		} else {
+			for _, child := range children(node) {
+				child.Unlink()
+				node.AddPrevSibling(child)
+			}
+		}
+		node.Unlink()
+		node.Free()
+	}
+}
+
+// alternateHref is the reference to the page of another locale, absolute
+// when the site has an url.
+func alternateHref(ctx context.Context, outPath string, alternate string) (string, error) {
+	config := siteConfig(ctx)
+	if config.URL != "" {
+		rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+		return pageURL(config.URL, filepath.Join(rootPath, config.Output), alternate)
+	}
+	relPath, err := filepath.Rel(filepath.Dir(outPath), alternate)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// addAlternateLinks adds hreflang links to the pages of every locale the
+// section is built for, and an x-default link to the page of the default
+// locale.
+func addAlternateLinks(ctx context.Context, document *markup.Document, xpath *markup.XPathContext, localization *Localization) error {
+	head := firstNode(xpath, "/html/head")
+	outPath, _ := ctx.Value(builder_context.OutPathContextKey).(string)
+	if head == nil || outPath == "" || outPath == "-" {
+		return nil
+	}
+	hreflangs := append([]string{}, localization.Locales...)
+	defaultLocale := siteConfig(ctx).I18n.DefaultLocale()
+	if _, ok := localization.Outputs[defaultLocale]; ok {
+		hreflangs = append(hreflangs, "x-default")
+	}
+	for _, hreflang := range hreflangs {
+		if firstNode(xpath, fmt.Sprintf("/html/head/link[@rel='alternate' and @hreflang=%s]", xpathLiteral(hreflang))) != nil {
+			continue
+		}
+		locale := hreflang
+		if hreflang == "x-default" {
+			locale = defaultLocale
+		}
+		alternate, err := localization.Alternate(outPath, locale)
+		if err != nil {
+			return err
+		}
+		href, err := alternateHref(ctx, outPath, alternate)
+		if err != nil {
+			return err
+		}
+		link := document.NewNode(nil, "link", "")
+		link.SetAttribute("rel", "alternate")
+		link.SetAttribute("hreflang", hreflang)
+		link.SetAttribute("href", href)
+		head.AddChild(link)
+	}
+	return nil
+}
+
+// TransformI18n translates a document into the locale it is built for, or
+// the locale of the argument, sets the lang attribute of the html element
+// and links the pages of sections built per locale to each other.
+func TransformI18n(ctx context.Context, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document to i18n transform")
+	}
+	locale := documentLocale(ctx)
+	if len(args) > 0 && args[0] != "" {
+		locale = args[0]
+	}
+	if locale == "" {
+		return ctx, Continue, errors.New("missing locale for i18n transform")
+	}
+	t, err := newTranslator(ctx, locale)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	translateDocument(document, xpath, t)
+	if root := document.Root(); root != nil && root.Name() == "html" {
+		root.SetAttribute("lang", locale)
+	}
+	if localization := documentLocalization(ctx); localization != nil {
+		if err := addAlternateLinks(ctx, document, xpath, localization); err != nil {
+			return ctx, Continue, err
+		}
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("i18n", TransformI18n)
+}
@@ -0,0 +1,294 @@
+package transformer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	defaultImageQuality int    = 80
+	defaultImageSizes   string = "100vw"
+)
+
+var defaultImageWidths = []int{480, 960, 1440}
+
+// ImagesConfig is the configuration of the images transformation. Images
+// smaller than inline bytes are replaced with data URIs, larger PNG and JPEG
+// images are resized to the widths that are smaller than the image.
+type ImagesConfig struct {
+	Widths  []int  `yaml:"widths"`
+	Sizes   string `yaml:"sizes"`
+	Quality int    `yaml:"quality"`
+	Inline  int64  `yaml:"inline"`
+}
+
+// sourceImage is an image file referenced by a document. It is decoded when
+// the first variant is written.
+type sourceImage struct {
+	path    string
+	data    []byte
+	hash    string
+	format  string
+	width   int
+	height  int
+	decoded image.Image
+}
+
+func readSourceImage(path string) (*sourceImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	img := &sourceImage{path: path, data: data, hash: hex.EncodeToString(sum[:])}
+	if filepath.Ext(path) == ".svg" {
+		img.format = "svg"
+		return img, nil
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	img.format = format
+	img.width = config.Width
+	img.height = config.Height
+	return img, nil
+}
+
+func (img *sourceImage) dataURI() string {
+	mediaType := mime.TypeByExtension(filepath.Ext(img.path))
+	if mediaType == "" {
+		mediaType = "image/" + img.format
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(img.data)
+}
+
+func (img *sourceImage) resizable() bool {
+	return img.format == "png" || img.format == "jpeg"
+}
+
+// variantName names a resized image after the source file, its width and the
+// hash of the source so that a changed source gets a new file.
+func (img *sourceImage) variantName(width int) string {
+	ext := filepath.Ext(img.path)
+	base := strings.TrimSuffix(filepath.Base(img.path), ext)
+	return fmt.Sprintf("%s-%dw.%s%s", base, width, img.hash[:8], ext)
+}
+
+func (img *sourceImage) decode() (image.Image, error) {
+	if img.decoded == nil {
+		decoded, _, err := image.Decode(bytes.NewReader(img.data))
+		if err != nil {
+			return nil, err
+		}
+		img.decoded = decoded
+	}
+	return img.decoded, nil
+}
+
+func (img *sourceImage) resize(outPath string, width int, quality int) error {
+	src, err := img.decode()
+	if err != nil {
+		return err
+	}
+	height := img.height * width / img.width
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buffer bytes.Buffer
+	if img.format == "jpeg" {
+		err = jpeg.Encode(&buffer, dst, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(&buffer, dst)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, buffer.Bytes(), 0644)
+}
+
+type imageProcessor struct {
+	ctx      context.Context
+	rootPath string
+	inPath   string
+	outPath  string
+	config   ImagesConfig
+}
+
+func (p *imageProcessor) resolve(ref string) string {
+	refPath, _ := splitURL(ref)
+	if strings.HasPrefix(refPath, "/") {
+		return filepath.Join(p.rootPath, filepath.FromSlash(refPath))
+	}
+	return filepath.Join(filepath.Dir(p.inPath), filepath.FromSlash(refPath))
+}
+
+// variants writes the resized versions of an image next to the output
+// document and returns the srcset of the image. Existing variants are reused,
+// their names change when the source image changes.
+func (p *imageProcessor) variants(img *sourceImage, ref string) (string, error) {
+	widths := p.config.Widths
+	if len(widths) == 0 {
+		widths = defaultImageWidths
+	}
+	widths = append([]int{}, widths...)
+	sort.Ints(widths)
+
+	quality := p.config.Quality
+	if quality <= 0 {
+		quality = defaultImageQuality
+	}
+
+	manifest, _ := p.ctx.Value(builder_context.ManifestContextKey).(*Manifest)
+	candidates := []string{}
+	for _, width := range widths {
+		if width <= 0 || width >= img.width {
+			continue
+		}
+		name := img.variantName(width)
+		variantPath := filepath.Join(filepath.Dir(p.outPath), name)
+		_, err := buildState(p.ctx).Load("images:"+variantPath, func() (interface{}, error) {
+			if _, err := os.Stat(variantPath); err == nil {
+				return variantPath, nil
+			}
+			return variantPath, img.resize(variantPath, width, quality)
+		})
+		if err != nil {
+			return "", err
+		}
+		if manifest != nil {
+			manifest.Add(img.path, variantPath)
+		}
+		candidates = append(candidates, name+" "+strconv.Itoa(width)+"w")
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	return strings.Join(append(candidates, ref+" "+strconv.Itoa(img.width)+"w"), ", "), nil
+}
+
+func (p *imageProcessor) report(node *markup.Node, err error) {
+	ReportDiagnostic(p.ctx, Diagnostic{
+		Severity: SeverityWarning,
+		File:     p.inPath,
+		Element:  node.Path(),
+		Message:  err.Error(),
+	})
+}
+
+// processImage inlines a small image or adds the srcset of its resized
+// versions. Dimensions are added to img elements.
+func (p *imageProcessor) processImage(node *markup.Node, attr string) error {
+	ref := strings.TrimSpace(node.GetAttribute(attr))
+	if !isLocalURL(ref) || strings.ContainsAny(ref, ", ") {
+		return nil
+	}
+	img, err := readSourceImage(p.resolve(ref))
+	if err != nil {
+		p.report(node, err)
+		return nil
+	}
+
+	isImg := node.Name() == "img"
+	if isImg && img.width > 0 && node.GetAttribute("width") == "" && node.GetAttribute("height") == "" {
+		node.SetAttribute("width", strconv.Itoa(img.width))
+		node.SetAttribute("height", strconv.Itoa(img.height))
+	}
+
+	if int64(len(img.data)) < p.config.Inline {
+		node.SetAttribute(attr, img.dataURI())
+		return nil
+	}
+	if !img.resizable() || (isImg && node.GetAttribute("srcset") != "") {
+		return nil
+	}
+
+	srcset, err := p.variants(img, ref)
+	if err != nil {
+		return err
+	}
+	if srcset == "" {
+		return nil
+	}
+	node.SetAttribute("srcset", srcset)
+	if node.GetAttribute("sizes") == "" {
+		sizes := p.config.Sizes
+		if sizes == "" {
+			sizes = defaultImageSizes
+		}
+		node.SetAttribute("sizes", sizes)
+	}
+	return nil
+}
+
+func TransformImages(ctx context.Context, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document")
+	}
+	rootPath, ok := ctx.Value(builder_context.RootPathContextKey).(string)
+	if !ok {
+		return ctx, Continue, errors.New("missing root path")
+	}
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	outPath, ok := ctx.Value(builder_context.OutPathContextKey).(string)
+	if !ok || outPath == "-" {
+		return ctx, Continue, errors.New("images transformation needs an output file")
+	}
+
+	p := &imageProcessor{ctx, rootPath, inPath, outPath, siteConfig(ctx).Images}
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	if elements := xpath.Eval("//img[@src]"); elements != nil {
+		for _, node := range elements.Results() {
+			if err := p.processImage(node, "src"); err != nil {
+				elements.Free()
+				return ctx, Continue, err
+			}
+		}
+		elements.Free()
+	}
+
+	// sources of picture elements listing a single image
+	if elements := xpath.Eval("//picture/source[@srcset]"); elements != nil {
+		for _, node := range elements.Results() {
+			if err := p.processImage(node, "srcset"); err != nil {
+				elements.Free()
+				return ctx, Continue, err
+			}
+		}
+		elements.Free()
+	}
+
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("images", TransformImages)
+}
@@ -0,0 +1,227 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gostatic/pkg/markup"
+)
+
+// LinkCheckConfig is the configuration of the linkcheck step. With fail the
+// build fails when a link is broken. External lists the links to other sites,
+// which are never fetched. Ignore holds glob patterns of urls not checked.
+type LinkCheckConfig struct {
+	Fail     bool     `yaml:"fail"`
+	External bool     `yaml:"external"`
+	Ignore   []string `yaml:"ignore"`
+}
+
+func (c LinkCheckConfig) ignores(ref string) bool {
+	for _, pattern := range c.Ignore {
+		if ok, _ := filepath.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkChecker checks the internal links of the HTML files of an output tree.
+type LinkChecker struct {
+	ctx      context.Context
+	config   LinkCheckConfig
+	outRoot  string
+	ids      map[string]map[string]bool
+	external map[string]bool
+	broken   int
+}
+
+func NewLinkChecker(ctx context.Context, outRoot string, config LinkCheckConfig) *LinkChecker {
+	return &LinkChecker{ctx, config, outRoot, make(map[string]map[string]bool), make(map[string]bool), 0}
+}
+
+// Broken returns the number of broken links found.
+func (c *LinkChecker) Broken() int {
+	return c.broken
+}
+
+// External returns the links to other sites found, sorted.
+func (c *LinkChecker) External() []string {
+	links := make([]string, 0, len(c.external))
+	for link := range c.external {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	return links
+}
+
+// anchors returns the ids and anchor names of an HTML file, read once.
+func (c *LinkChecker) anchors(path string) map[string]bool {
+	if ids, ok := c.ids[path]; ok {
+		return ids
+	}
+	ids := make(map[string]bool)
+	c.ids[path] = ids
+	document, err := readOutputDocument(path)
+	if err != nil {
+		return ids
+	}
+	defer document.Free()
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+	if elements := xpath.Eval("//*[@id] | //a[@name]"); elements != nil {
+		for _, node := range elements.Results() {
+			if id := node.GetAttribute("id"); id != "" {
+				ids[id] = true
+			}
+			if name := node.GetAttribute("name"); node.Name() == "a" && name != "" {
+				ids[name] = true
+			}
+		}
+		elements.Free()
+	}
+	return ids
+}
+
+// target returns the file a link points to. Directories are served by their
+// index.html and pages may be linked without their .html extension.
+func target(path string) (string, bool) {
+	if info, err := os.Stat(path); err == nil {
+		if !info.IsDir() {
+			return path, true
+		}
+		index := filepath.Join(path, "index.html")
+		if _, err := os.Stat(index); err == nil {
+			return index, true
+		}
+		return "", false
+	}
+	if filepath.Ext(path) == "" {
+		if _, err := os.Stat(path + ".html"); err == nil {
+			return path + ".html", true
+		}
+	}
+	return "", false
+}
+
+func (c *LinkChecker) report(page string, node *markup.Node, message string) {
+	severity := SeverityWarning
+	if c.config.Fail {
+		severity = SeverityError
+	}
+	c.broken++
+	ReportDiagnostic(c.ctx, Diagnostic{
+		Severity: severity,
+		File:     page,
+		Element:  node.Path(),
+		Message:  message,
+	})
+}
+
+func (c *LinkChecker) checkRef(page string, node *markup.Node, ref string) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || c.config.ignores(ref) {
+		return
+	}
+	if !isLocalURL(ref) && !strings.HasPrefix(ref, "#") {
+		if u, err := url.Parse(ref); err == nil && (u.Scheme == "http" || u.Scheme == "https" || strings.HasPrefix(ref, "//")) {
+			c.external[ref] = true
+		}
+		return
+	}
+
+	path := page
+	if !strings.HasPrefix(ref, "#") {
+		resolved, ok := resolveOutputURL(ref, page, c.outRoot)
+		if !ok {
+			return
+		}
+		if strings.HasSuffix(strings.SplitN(ref, "?", 2)[0], "/") {
+			resolved = filepath.Join(resolved, "index.html")
+		}
+		if path, ok = target(resolved); !ok {
+			c.report(page, node, fmt.Sprintf("broken link: %s", ref))
+			return
+		}
+	}
+
+	_, suffix := splitURL(ref)
+	if i := strings.Index(suffix, "#"); i >= 0 && filepath.Ext(path) == ".html" {
+		fragment := suffix[i+1:]
+		if unescaped, err := url.PathUnescape(fragment); err == nil {
+			fragment = unescaped
+		}
+		if fragment != "" && fragment != "top" && !c.anchors(path)[fragment] {
+			c.report(page, node, fmt.Sprintf("broken anchor: %s", ref))
+		}
+	}
+}
+
+// Check checks the href, src and srcset links of an HTML file.
+func (c *LinkChecker) Check(page string) error {
+	document, err := readOutputDocument(page)
+	if err != nil {
+		return err
+	}
+	defer document.Free()
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	elements := xpath.Eval("//*[@href or @src or @srcset]")
+	if elements == nil {
+		return nil
+	}
+	defer elements.Free()
+
+	for _, node := range elements.Results() {
+		for _, name := range []string{"href", "src"} {
+			if attr := node.HasAttribute(name); attr != nil {
+				c.checkRef(page, node, node.GetAttribute(name))
+			}
+		}
+		if srcset := node.GetAttribute("srcset"); srcset != "" {
+			for _, candidate := range strings.Split(srcset, ",") {
+				if fields := strings.Fields(candidate); len(fields) > 0 {
+					c.checkRef(page, node, fields[0])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TransformLinkCheck checks the links of the HTML pages of the build. It is
+// a step listed under after.
+func TransformLinkCheck(ctx context.Context, args []string) (context.Context, Status, error) {
+	manifest, err := buildManifest(ctx)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	config := siteConfig(ctx).LinkCheck
+	checker := NewLinkChecker(ctx, outputRoot(ctx), config)
+	for _, page := range manifest.Outputs(".html") {
+		if err := checker.Check(page); err != nil {
+			return ctx, Continue, err
+		}
+	}
+	if config.External {
+		for _, link := range checker.External() {
+			ReportDiagnostic(ctx, Diagnostic{Severity: SeverityInfo, Message: fmt.Sprintf("external link: %s", link)})
+		}
+	}
+	if config.Fail && checker.Broken() > 0 {
+		return ctx, Continue, errors.New(fmt.Sprintf("%d broken link(s)", checker.Broken()))
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("linkcheck", TransformLinkCheck)
+}
@@ -0,0 +1,190 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+var (
+	// [[Page Title]], [[Page Title#anchor]] or [[Page Title|label]]
+	wikiLinkPattern  = regexp.MustCompile(`\[\[([^\]|#]+)(#[^\]|]*)?(?:\|([^\]]+))?\]\]`)
+	sourceExtensions = map[string]bool{".md": true, ".markdown": true}
+)
+
+type linkResolver struct {
+	ctx     context.Context
+	plan    *Plan
+	root    string
+	inPath  string
+	outPath string
+}
+
+func newLinkResolver(ctx context.Context) (*linkResolver, error) {
+	rootPath, ok := ctx.Value(builder_context.RootPathContextKey).(string)
+	if !ok {
+		return nil, errors.New("missing root path")
+	}
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	outPath, ok := ctx.Value(builder_context.OutPathContextKey).(string)
+	if !ok || outPath == "-" {
+		return nil, errors.New("links need an output file")
+	}
+	return &linkResolver{ctx, buildPlan(ctx), rootPath, inPath, outPath}, nil
+}
+
+func (r *linkResolver) warn(node *markup.Node, message string) {
+	ReportDiagnostic(r.ctx, Diagnostic{
+		Severity: SeverityWarning,
+		File:     r.inPath,
+		Element:  node.Path(),
+		Message:  message,
+	})
+}
+
+// url returns the reference from the page being built to another output file.
+func (r *linkResolver) url(outPath string, suffix string) string {
+	if outPath == r.outPath {
+		if suffix == "" {
+			return "#"
+		}
+		return suffix
+	}
+	relPath, err := filepath.Rel(filepath.Dir(r.outPath), outPath)
+	if err != nil {
+		return filepath.ToSlash(outPath) + suffix
+	}
+	return filepath.ToSlash(relPath) + suffix
+}
+
+// resolveHref rewrites a link to a source file, relative to basePath, into a
+// link to the file it is built into.
+func (r *linkResolver) resolveHref(node *markup.Node, basePath string) {
+	href := node.GetAttribute("href")
+	if !isLocalURL(href) {
+		return
+	}
+	refPath, suffix := splitURL(href)
+	if refPath == "" {
+		return
+	}
+	if unescaped, err := url.PathUnescape(refPath); err == nil {
+		refPath = unescaped
+	}
+	target := filepath.Join(filepath.Dir(basePath), filepath.FromSlash(refPath))
+	if strings.HasPrefix(refPath, "/") {
+		target = filepath.Join(r.root, filepath.FromSlash(refPath))
+	}
+	if absTarget, err := filepath.Abs(target); err == nil {
+		target = absTarget
+	}
+	if outPath, ok := r.plan.Output(target); ok {
+		node.SetAttribute("href", r.url(outPath, suffix))
+	} else if sourceExtensions[strings.ToLower(filepath.Ext(target))] {
+		r.warn(node, fmt.Sprintf("unresolved link: %s", href))
+	}
+}
+
+// resolveWikiLinks replaces [[Page Title]] in a text node with links. The
+// text node keeps the text before the first link, libxml2 merges adjacent
+// text nodes so the rest is added after the links.
+func (r *linkResolver) resolveWikiLinks(document *markup.Document, text *markup.Node) {
+	content := text.GetContent()
+	matches := wikiLinkPattern.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return
+	}
+	parent := text.Parent()
+	last := text
+	pending := ""
+	position := 0
+	for _, match := range matches {
+		title := strings.TrimSpace(content[match[2]:match[3]])
+		anchor := ""
+		if match[4] >= 0 {
+			anchor = content[match[4]:match[5]]
+		}
+		label := title
+		if match[6] >= 0 {
+			label = strings.TrimSpace(content[match[6]:match[7]])
+		}
+		outPath, ok := r.plan.Lookup(title)
+		if !ok {
+			r.warn(parent, fmt.Sprintf("unresolved wiki link: %s", title))
+			pending += content[position:match[1]]
+			position = match[1]
+			continue
+		}
+		pending += content[position:match[0]]
+		if last == text {
+			text.SetContent(pending)
+		} else if pending != "" {
+			last = last.AddNextSibling(document.NewText(pending).Node)
+		}
+		link := document.NewNode(nil, "a", "")
+		link.SetAttribute("href", r.url(outPath, anchor))
+		setTextContent(document, link, label)
+		last = last.AddNextSibling(link)
+		pending = ""
+		position = match[1]
+	}
+	if last == text {
+		return
+	}
+	if pending += content[position:]; pending != "" {
+		last.AddNextSibling(document.NewText(pending).Node)
+	}
+	if text.GetContent() == "" {
+		text.Unlink()
+		text.Free()
+	}
+}
+
+// resolve rewrites links to source files and wiki links inside scope, an
+// XPath expression, or the whole document when scope is empty. Relative
+// links are resolved against basePath.
+func (r *linkResolver) resolve(document *markup.Document, scope string, basePath string) {
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	if elements := xpath.Eval(scope + "//a[@href]"); elements != nil {
+		for _, node := range elements.Results() {
+			r.resolveHref(node, basePath)
+		}
+		elements.Free()
+	}
+
+	texts := xpath.Eval(scope + "//text()[contains(., '[[') and not(ancestor::code or ancestor::pre or ancestor::script or ancestor::style or ancestor::a)]")
+	if texts != nil {
+		for _, node := range texts.Results() {
+			r.resolveWikiLinks(document, node)
+		}
+		texts.Free()
+	}
+}
+
+// TransformLinks resolves links to source files and wiki links of a
+// document into links to the output files of the build.
+func TransformLinks(ctx context.Context, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document")
+	}
+	r, err := newLinkResolver(ctx)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	r.resolve(document, "", r.inPath)
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("links", TransformLinks)
+}
@@ -0,0 +1,209 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+var (
+	lintSeverities = map[string]Severity{
+		"info":    SeverityInfo,
+		"warning": SeverityWarning,
+		"error":   SeverityError,
+	}
+	deprecatedElements = strings.Split("acronym,applet,basefont,bgsound,big,blink,center,dir,font,frame,frameset,isindex,keygen,marquee,menuitem,nobr,noembed,noframes,plaintext,rb,rtc,spacer,strike,tt,xmp", ",")
+)
+
+// LintConfig is the configuration of the lint transformation. Rules maps a
+// rule name to its severity, info, warning, error or off. With fail the build
+// fails when a rule with severity error finds a problem. Named profiles
+// override the top level settings and are selected with the first argument,
+// e.g. lint:docs.
+type LintConfig struct {
+	Rules    map[string]string     `yaml:"rules"`
+	Fail     *bool                 `yaml:"fail"`
+	Profiles map[string]LintConfig `yaml:"profiles"`
+}
+
+func (c LintConfig) Profile(name string) (LintConfig, error) {
+	if name == "" {
+		return c, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return c, errors.New(fmt.Sprintf("unknown lint profile: %s", name))
+	}
+	rules := make(map[string]string, len(c.Rules)+len(profile.Rules))
+	for rule, severity := range c.Rules {
+		rules[rule] = severity
+	}
+	for rule, severity := range profile.Rules {
+		rules[rule] = severity
+	}
+	c.Rules = rules
+	if profile.Fail != nil {
+		c.Fail = profile.Fail
+	}
+	c.Profiles = nil
+	return c, nil
+}
+
+type lintProblem struct {
+	node    *markup.Node
+	message string
+}
+
+type lintRule func(xpath *markup.XPathContext) []lintProblem
+
+// lintSelect reports every node an XPath expression selects.
+func lintSelect(expression string, message func(node *markup.Node) string) lintRule {
+	return func(xpath *markup.XPathContext) []lintProblem {
+		problems := []lintProblem{}
+		elements := xpath.Eval(expression)
+		if elements == nil {
+			return problems
+		}
+		defer elements.Free()
+		for _, node := range elements.Results() {
+			problems = append(problems, lintProblem{node, message(node)})
+		}
+		return problems
+	}
+}
+
+func lintHeadingOrder(xpath *markup.XPathContext) []lintProblem {
+	problems := []lintProblem{}
+	elements := xpath.Eval("//*[self::h1 or self::h2 or self::h3 or self::h4 or self::h5 or self::h6]")
+	if elements == nil {
+		return problems
+	}
+	defer elements.Free()
+	previous := 0
+	for _, node := range elements.Results() {
+		level, _ := strconv.Atoi(strings.TrimPrefix(node.Name(), "h"))
+		if previous > 0 && level > previous+1 {
+			problems = append(problems, lintProblem{node, fmt.Sprintf("heading level skipped: h%d after h%d", level, previous)})
+		}
+		previous = level
+	}
+	return problems
+}
+
+func lintDuplicateIDs(xpath *markup.XPathContext) []lintProblem {
+	problems := []lintProblem{}
+	elements := xpath.Eval("//*[@id]")
+	if elements == nil {
+		return problems
+	}
+	defer elements.Free()
+	ids := make(map[string]bool)
+	for _, node := range elements.Results() {
+		id := node.GetAttribute("id")
+		if ids[id] {
+			problems = append(problems, lintProblem{node, fmt.Sprintf("duplicate id: %s", id)})
+		}
+		ids[id] = true
+	}
+	return problems
+}
+
+func deprecatedExpression() string {
+	tests := make([]string, len(deprecatedElements))
+	for i, name := range deprecatedElements {
+		tests[i] = "self::" + name
+	}
+	return fmt.Sprintf("//*[%s]", strings.Join(tests, " or "))
+}
+
+var lintRules = map[string]lintRule{
+	"img-alt": lintSelect("//img[not(@alt) and not(@role='presentation' or @role='none')]", func(node *markup.Node) string {
+		return fmt.Sprintf("image without alt text: %s", node.GetAttribute("src"))
+	}),
+	"heading-order": lintHeadingOrder,
+	"duplicate-id":  lintDuplicateIDs,
+	"empty-link": lintSelect("//a[@href and not(normalize-space(.)) and not(.//img[normalize-space(@alt)]) and not(@aria-label or @aria-labelledby or @title)]", func(node *markup.Node) string {
+		return fmt.Sprintf("link without text: %s", node.GetAttribute("href"))
+	}),
+	"html-lang": lintSelect("/html[not(normalize-space(@lang))]", func(node *markup.Node) string {
+		return "html element without lang attribute"
+	}),
+	"form-label": lintSelect("//*[self::select or self::textarea or self::input[not(@type='hidden' or @type='submit' or @type='button' or @type='reset' or @type='image')]][not(@aria-label or @aria-labelledby or @title or ancestor::label or (@id and @id = //label/@for))]", func(node *markup.Node) string {
+		return fmt.Sprintf("%s without label", node.Name())
+	}),
+	"deprecated": lintSelect(deprecatedExpression(), func(node *markup.Node) string {
+		return fmt.Sprintf("deprecated element: %s", node.Name())
+	}),
+}
+
+// TransformLint checks a document for accessibility and HTML problems and
+// reports them as build diagnostics. The optional argument is a profile.
+func TransformLint(ctx context.Context, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document")
+	}
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+
+	profile := ""
+	if len(args) > 0 {
+		profile = args[0]
+	}
+	config, err := siteConfig(ctx).Lint.Profile(profile)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	names := make([]string, 0, len(lintRules))
+	for name := range lintRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for name := range config.Rules {
+		if _, ok := lintRules[name]; !ok {
+			return ctx, Continue, errors.New(fmt.Sprintf("unknown lint rule: %s", name))
+		}
+	}
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	failed := 0
+	for _, name := range names {
+		severity := SeverityWarning
+		if value, ok := config.Rules[name]; ok {
+			if value == "off" {
+				continue
+			}
+			if severity, ok = lintSeverities[value]; !ok {
+				return ctx, Continue, errors.New(fmt.Sprintf("unknown lint severity: %s", value))
+			}
+		}
+		for _, problem := range lintRules[name](xpath) {
+			ReportDiagnostic(ctx, Diagnostic{
+				Severity: severity,
+				File:     inPath,
+				Element:  problem.node.Path(),
+				Message:  fmt.Sprintf("%s: %s", name, problem.message),
+			})
+			if severity == SeverityError {
+				failed++
+			}
+		}
+	}
+
+	if config.Fail != nil && *config.Fail && failed > 0 {
+		return ctx, Continue, errors.New(fmt.Sprintf("%s: %d lint error(s)", inPath, failed))
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("lint", TransformLint)
+}
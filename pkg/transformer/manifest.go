@@ -0,0 +1,160 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+// ManifestEntry is an input file and the output file it was built into.
+// Meta is the frontmatter of the markdown sources of the output file and
+// Serializer the options it was serialized with, nil for the defaults.
+type ManifestEntry struct {
+	InPath     string
+	OutPath    string
+	Meta       Metadata
+	Serializer *markup.HTML5SerializerOptions
+}
+
+// Manifest records every output file written during a site build.
+type Manifest struct {
+	mutex   sync.Mutex
+	entries []ManifestEntry
+}
+
+func NewManifest() *Manifest {
+	return &Manifest{}
+}
+
+func (m *Manifest) Add(inPath string, outPath string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.entries {
+		if m.entries[i].OutPath == outPath {
+			m.entries[i].InPath = inPath
+			return
+		}
+	}
+	m.entries = append(m.entries, ManifestEntry{InPath: inPath, OutPath: outPath})
+}
+
+// SetMeta adds metadata to the entry of an output file.
+func (m *Manifest) SetMeta(inPath string, outPath string, meta Metadata) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.entries {
+		if m.entries[i].OutPath == outPath {
+			m.entries[i].Meta = m.entries[i].Meta.Merge(meta)
+			return
+		}
+	}
+	m.entries = append(m.entries, ManifestEntry{InPath: inPath, OutPath: outPath, Meta: Metadata{}.Merge(meta)})
+}
+
+// SetSerializerOptions records the options an output file was serialized
+// with, so that steps rewriting it after the build keep them.
+func (m *Manifest) SetSerializerOptions(inPath string, outPath string, options markup.HTML5SerializerOptions) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.entries {
+		if m.entries[i].OutPath == outPath {
+			m.entries[i].Serializer = &options
+			return
+		}
+	}
+	m.entries = append(m.entries, ManifestEntry{InPath: inPath, OutPath: outPath, Serializer: &options})
+}
+
+// SerializerOptions returns the options an output file was serialized with.
+func (m *Manifest) SerializerOptions(outPath string) (markup.HTML5SerializerOptions, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.entries {
+		if m.entries[i].OutPath == outPath && m.entries[i].Serializer != nil {
+			return *m.entries[i].Serializer, true
+		}
+	}
+	return markup.HTML5SerializerOptions{}, false
+}
+
+func (m *Manifest) Rename(oldPath string, newPath string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i := range m.entries {
+		if m.entries[i].OutPath == oldPath {
+			m.entries[i].OutPath = newPath
+		}
+	}
+}
+
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entries := make([]ManifestEntry, len(m.entries))
+	copy(entries, m.entries)
+	return entries
+}
+
+func (m *Manifest) Outputs(ext string) []string {
+	paths := []string{}
+	for _, entry := range m.Entries() {
+		if filepath.Ext(entry.OutPath) == ext {
+			paths = append(paths, entry.OutPath)
+		}
+	}
+	return paths
+}
+
+// CommonDir returns the deepest directory containing every output file.
+func (m *Manifest) CommonDir() string {
+	common := ""
+	for _, entry := range m.Entries() {
+		dir := filepath.Dir(entry.OutPath)
+		if common == "" {
+			common = dir
+			continue
+		}
+		for common != dir && !strings.HasPrefix(dir, common+string(os.PathSeparator)) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				break
+			}
+			common = parent
+		}
+	}
+	return common
+}
+
+func buildManifest(ctx context.Context) (*Manifest, error) {
+	manifest, ok := ctx.Value(builder_context.ManifestContextKey).(*Manifest)
+	if !ok {
+		return nil, errors.New("missing build manifest")
+	}
+	return manifest, nil
+}
+
+// outputRoot is the directory that root relative urls ("/app.css") resolve against.
+func outputRoot(ctx context.Context) string {
+	rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+	if output := siteConfig(ctx).Output; output != "" {
+		return filepath.Join(rootPath, output)
+	}
+	if manifest, ok := ctx.Value(builder_context.ManifestContextKey).(*Manifest); ok {
+		if dir := manifest.CommonDir(); dir != "" {
+			return dir
+		}
+	}
+	return rootPath
+}
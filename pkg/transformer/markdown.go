@@ -2,6 +2,8 @@ package transformer
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -10,12 +12,115 @@ import (
 	"unicode/utf8"
 
 	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markdown"
 	"gostatic/pkg/markup"
 )
 
+const defaultMarkdownStyle string = "monokai"
+
+var defaultMarkdownExtensions = []string{"frontmatter", "fences", "pikchr", "mathjax", "highlighting"}
+
+type MarkdownHighlightConfig struct {
+	Style       string `yaml:"style"`
+	LineNumbers *bool  `yaml:"lineNumbers"`
+	Classes     *bool  `yaml:"classes"`
+}
+
+// MarkdownConfig is the configuration of the markdown transformation. Named
+// profiles override the top level settings and are selected with the first
+// argument of the transformation, e.g. markdown:docs.
+type MarkdownConfig struct {
+	Extensions  []string                  `yaml:"extensions"`
+	Highlight   MarkdownHighlightConfig   `yaml:"highlight"`
+	HeadingIDs  *bool                     `yaml:"headingIDs"`
+	Unsafe      *bool                     `yaml:"unsafe"`
+	Frontmatter MarkdownFrontmatterConfig `yaml:"frontmatter"`
+	Profiles    map[string]MarkdownConfig `yaml:"profiles"`
+}
+
+// MarkdownFrontmatterConfig sets where the frontmatter of a markdown source
+// goes. Element is meta (meta child elements), attributes (data attributes)
+// or none. Document adds meta elements and the title to the document head.
+type MarkdownFrontmatterConfig struct {
+	Element  string `yaml:"element"`
+	Document *bool  `yaml:"document"`
+}
+
+func (c MarkdownConfig) Profile(name string) (MarkdownConfig, error) {
+	if name == "" {
+		return c, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return c, errors.New(fmt.Sprintf("unknown markdown profile: %s", name))
+	}
+	if profile.Extensions != nil {
+		c.Extensions = profile.Extensions
+	}
+	if profile.Highlight.Style != "" {
+		c.Highlight.Style = profile.Highlight.Style
+	}
+	if profile.Highlight.LineNumbers != nil {
+		c.Highlight.LineNumbers = profile.Highlight.LineNumbers
+	}
+	if profile.Highlight.Classes != nil {
+		c.Highlight.Classes = profile.Highlight.Classes
+	}
+	if profile.HeadingIDs != nil {
+		c.HeadingIDs = profile.HeadingIDs
+	}
+	if profile.Unsafe != nil {
+		c.Unsafe = profile.Unsafe
+	}
+	if profile.Frontmatter.Element != "" {
+		c.Frontmatter.Element = profile.Frontmatter.Element
+	}
+	if profile.Frontmatter.Document != nil {
+		c.Frontmatter.Document = profile.Frontmatter.Document
+	}
+	c.Profiles = nil
+	return c, nil
+}
+
+// markdownConverter returns the converter of a profile, it is made once per build.
+func markdownConverter(ctx context.Context, profile string, config MarkdownConfig) (*markdown.Converter, error) {
+	value, err := buildState(ctx).Load("markdown:"+profile, func() (interface{}, error) {
+		return configMarkdownConverter(config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*markdown.Converter), nil
+}
+
 func TransformMarkdown(ctx context.Context, args []string) (context.Context, Status, error) {
 
 	document := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	profile := ""
+	if len(args) > 0 {
+		profile = args[0]
+	}
+	config, err := siteConfig(ctx).Markdown.Profile(profile)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	switch config.Frontmatter.Element {
+	case "", "meta", "attributes", "none":
+	default:
+		return ctx, Continue, errors.New(fmt.Sprintf("unknown frontmatter element mode: %s", config.Frontmatter.Element))
+	}
+	converter, err := markdownConverter(ctx, profile, config)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	metadata := Metadata{}
+
+	// links are resolved when the site build planned its output
+	var links *linkResolver
+	if _, ok := ctx.Value(builder_context.PlanContextKey).(*Plan); ok {
+		links, _ = newLinkResolver(ctx)
+	}
+
 	xpath := markup.NewXPathContext(document)
 	defer xpath.Free()
 
@@ -76,11 +181,20 @@ func TransformMarkdown(ctx context.Context, args []string) (context.Context, Sta
 			sourcePath = inPath
 		}
 		node.SetContent("")
-		converter := configMarkdownConverter()
-		err = converter.Convert(bytes, document, node)
+		meta, err := converter.Convert(bytes, document, node)
 		if err != nil {
 			return ctx, Continue, err
 		}
+		switch config.Frontmatter.Element {
+		case "", "meta":
+			Metadata(meta).addMetaElements(document, node)
+		case "attributes":
+			Metadata(meta).addAttributes(node)
+		}
+		metadata = metadata.Merge(meta)
+		if links != nil {
+			links.resolve(document, node.Path(), sourcePath)
+		}
 
 		fileInfo, err := os.Stat(sourcePath)
 		if err != nil {
@@ -97,6 +211,11 @@ func TransformMarkdown(ctx context.Context, args []string) (context.Context, Sta
 		}
 	}
 
+	if config.Frontmatter.Document == nil || *config.Frontmatter.Document {
+		metadata.addToHead(document)
+	}
+	ctx = withMetadata(ctx, metadata)
+
 	return ctx, Continue, nil
 }
 
@@ -0,0 +1,196 @@
+package transformer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+var metadataNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// Metadata is the frontmatter of a markdown source, e.g. title, date, tags
+// and layout.
+type Metadata map[string]interface{}
+
+// Merge returns a copy of m with the values of other added to it.
+func (m Metadata) Merge(other Metadata) Metadata {
+	merged := make(Metadata, len(m)+len(other))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Keys returns the names of the values that can be used as XML names, sorted.
+func (m Metadata) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if metadataNamePattern.MatchString(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String returns a value as text. Lists are joined with commas.
+func (m Metadata) String(key string) string {
+	return strings.Join(m.Values(key), ",")
+}
+
+// Values returns the items of a list value or the value itself.
+func (m Metadata) Values(key string) []string {
+	value, ok := m[key]
+	if !ok || value == nil {
+		return []string{}
+	}
+	if list, ok := value.([]interface{}); ok {
+		values := []string{}
+		for _, item := range list {
+			if item != nil {
+				values = append(values, metadataString(item))
+			}
+		}
+		return values
+	}
+	return []string{metadataString(value)}
+}
+
+func metadataString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// addMetaElements adds a meta element for each value, or each item of a
+// list value, to the start of node.
+func (m Metadata) addMetaElements(document *markup.Document, node *markup.Node) {
+	first := node.FirstChildNode()
+	for _, key := range m.Keys() {
+		for _, value := range m.Values(key) {
+			meta := document.NewNode(nil, "meta", "")
+			meta.SetAttribute("name", key)
+			meta.SetAttribute("content", value)
+			if first != nil {
+				first.AddPrevSibling(meta)
+			} else {
+				node.AddChild(meta)
+			}
+		}
+	}
+}
+
+// addAttributes adds a data attribute for each value to node. The items of a
+// list value are separated by spaces.
+func (m Metadata) addAttributes(node *markup.Node) {
+	for _, key := range m.Keys() {
+		node.SetAttribute("data-"+strings.ToLower(key), strings.Join(m.Values(key), " "))
+	}
+}
+
+// addToHead adds meta elements for the values the head does not have yet and
+// sets an empty title.
+func (m Metadata) addToHead(document *markup.Document) {
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	elements := xpath.Eval("/html/head")
+	if elements == nil {
+		return
+	}
+	defer elements.Free()
+	results := elements.Results()
+	if len(results) == 0 {
+		return
+	}
+	head := results[0]
+
+	for _, key := range m.Keys() {
+		if key == "title" {
+			if titles := xpath.Eval("/html/head/title"); titles != nil {
+				results := titles.Results()
+				titles.Free()
+				if len(results) == 0 {
+					head.AddChild(document.NewNode(nil, "title", ""))
+					setTextContent(document, head.LastChild(), m.String(key))
+				} else if strings.TrimSpace(results[0].GetContent()) == "" {
+					setTextContent(document, results[0], m.String(key))
+				}
+			}
+			continue
+		}
+		if existing := xpath.Eval(fmt.Sprintf("/html/head/meta[@name='%s']", key)); existing != nil {
+			found := len(existing.Results()) > 0
+			existing.Free()
+			if found {
+				continue
+			}
+		}
+		meta := document.NewNode(nil, "meta", "")
+		meta.SetAttribute("name", key)
+		meta.SetAttribute("content", m.String(key))
+		head.AddChild(meta)
+	}
+}
+
+// documentMetadata returns the metadata collected for the current file.
+func documentMetadata(ctx context.Context) Metadata {
+	if meta, ok := ctx.Value(builder_context.MetadataContextKey).(Metadata); ok {
+		return meta
+	}
+	return Metadata{}
+}
+
+// withMetadata adds metadata to the current file. The values become string
+// parameters of stylesheets and are recorded in the site manifest.
+func withMetadata(ctx context.Context, meta Metadata) context.Context {
+	if len(meta) == 0 {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, builder_context.MetadataContextKey, documentMetadata(ctx).Merge(meta))
+
+	if strparams, ok := ctx.Value(builder_context.StringParamsContextKey).([]string); ok {
+		params := make([]string, len(strparams))
+		copy(params, strparams)
+		for _, key := range meta.Keys() {
+			i := 0
+			for i < len(params) && params[i] != key {
+				i += 2
+			}
+			if i < len(params) {
+				if key == "basePath" {
+					continue
+				}
+				params[i+1] = meta.String(key)
+			} else {
+				params = append(params, key, meta.String(key))
+			}
+		}
+		ctx = context.WithValue(ctx, builder_context.StringParamsContextKey, params)
+	}
+
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	outPath, ok := ctx.Value(builder_context.OutPathContextKey).(string)
+	if manifest, _ := ctx.Value(builder_context.ManifestContextKey).(*Manifest); manifest != nil && ok && outPath != "-" {
+		manifest.SetMeta(inPath, outPath, meta)
+	}
+
+	return ctx
+}
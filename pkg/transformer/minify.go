@@ -0,0 +1,210 @@
+package transformer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+var (
+	whitespacePattern = regexp.MustCompile(`[ \t\n\f\r]+`)
+	blockElements     map[string]bool
+	booleanAttributes map[string]bool
+	scriptTypes       = map[string]bool{"": true, "text/javascript": true, "application/javascript": true, "module": true}
+)
+
+// MinifyConfig is the configuration of the whitespace:minify transformation.
+// Whitespace is collapsed, comments other than conditional and license
+// comments are removed and boolean attributes are shortened. CSS and JS
+// minify inline styles and scripts, EndTags and Quotes leave out optional
+// end tags and attribute quotes when the document is written.
+type MinifyConfig struct {
+	CSS      *bool `yaml:"css"`
+	JS       *bool `yaml:"js"`
+	Comments bool  `yaml:"comments"`
+	EndTags  bool  `yaml:"endTags"`
+	Quotes   bool  `yaml:"quotes"`
+}
+
+type minifier struct {
+	ctx    context.Context
+	config MinifyConfig
+	inPath string
+}
+
+func (m *minifier) warn(node *markup.Node, message string) {
+	ReportDiagnostic(m.ctx, Diagnostic{
+		Severity: SeverityWarning,
+		File:     m.inPath,
+		Element:  node.Path(),
+		Message:  message,
+	})
+}
+
+func isBlock(node *markup.Node) bool {
+	return node.Type() == markup.XML_ELEMENT_NODE && blockElements[node.Name()]
+}
+
+// collapseText collapses whitespace of a text node and trims it next to
+// block elements, where browsers do not render it.
+func (m *minifier) collapseText(node *markup.Node) {
+	content := whitespacePattern.ReplaceAllString(node.GetContent(), " ")
+	parent := node.Parent()
+	if strings.HasPrefix(content, " ") {
+		if prev := node.Prev(); (prev == nil && parent != nil && isBlock(parent)) || (prev != nil && isBlock(prev)) {
+			content = content[1:]
+		}
+	}
+	if strings.HasSuffix(content, " ") {
+		if next := node.Next(); (next == nil && parent != nil && isBlock(parent)) || (next != nil && isBlock(next)) {
+			content = content[:len(content)-1]
+		}
+	}
+	if content == "" {
+		node.Unlink()
+		node.Free()
+	} else {
+		node.SetContent(content)
+	}
+}
+
+// keepComment reports whether a comment is a conditional comment or a
+// license comment.
+func keepComment(content string) bool {
+	return strings.HasPrefix(content, "[if") ||
+		strings.HasPrefix(content, "<![endif") ||
+		strings.HasPrefix(content, "!") ||
+		strings.Contains(content, "@license") ||
+		strings.Contains(content, "@preserve")
+}
+
+func (m *minifier) transform(node *markup.Node, code string, loader api.Loader) (string, bool) {
+	result := api.Transform(code, api.TransformOptions{
+		Loader:            loader,
+		MinifyWhitespace:  true,
+		MinifySyntax:      true,
+		MinifyIdentifiers: true,
+		LegalComments:     api.LegalCommentsInline,
+	})
+	if len(result.Errors) > 0 {
+		m.warn(node, fmt.Sprintf("cannot minify: %s", result.Errors[0].Text))
+		return code, false
+	}
+	return strings.TrimSuffix(string(result.Code), "\n"), true
+}
+
+// minifyStyleAttribute minifies the declarations of a style attribute as the
+// body of a rule.
+func (m *minifier) minifyStyleAttribute(node *markup.Node) {
+	style := node.GetAttribute("style")
+	code, ok := m.transform(node, "*{"+style+"}", api.LoaderCSS)
+	if !ok {
+		return
+	}
+	start, end := strings.Index(code, "{"), strings.LastIndex(code, "}")
+	if start < 0 || end < start {
+		return
+	}
+	node.SetAttribute("style", code[start+1:end])
+}
+
+func (m *minifier) minifyContent(document *markup.Document, node *markup.Node, loader api.Loader) {
+	content := node.GetContent()
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	if code, ok := m.transform(node, content, loader); ok {
+		setTextContent(document, node, code)
+	}
+}
+
+func (m *minifier) minify(document *markup.Document) {
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	if !m.config.Comments {
+		if comments := xpath.Eval("//comment()"); comments != nil {
+			for _, node := range comments.Results() {
+				if !keepComment(node.GetContent()) {
+					node.Unlink()
+					node.Free()
+				}
+			}
+			comments.Free()
+		}
+	}
+
+	if texts := xpath.Eval("//text()[not(ancestor::pre or ancestor::textarea or ancestor::listing or ancestor::plaintext or ancestor::script or ancestor::style)]"); texts != nil {
+		for _, node := range texts.Results() {
+			m.collapseText(node)
+		}
+		texts.Free()
+	}
+
+	if elements := xpath.Eval("//*[@*]"); elements != nil {
+		for _, node := range elements.Results() {
+			for attr := node.Attributes(); attr != nil; attr = attr.Next() {
+				if booleanAttributes[attr.Name()] && strings.EqualFold(node.GetAttribute(attr.Name()), attr.Name()) {
+					node.SetAttribute(attr.Name(), "")
+				}
+			}
+		}
+		elements.Free()
+	}
+
+	if m.config.CSS == nil || *m.config.CSS {
+		if elements := xpath.Eval("//style"); elements != nil {
+			for _, node := range elements.Results() {
+				m.minifyContent(document, node, api.LoaderCSS)
+			}
+			elements.Free()
+		}
+		if elements := xpath.Eval("//*[@style]"); elements != nil {
+			for _, node := range elements.Results() {
+				m.minifyStyleAttribute(node)
+			}
+			elements.Free()
+		}
+	}
+
+	if m.config.JS == nil || *m.config.JS {
+		if elements := xpath.Eval("//script[not(@src)]"); elements != nil {
+			for _, node := range elements.Results() {
+				if scriptTypes[strings.ToLower(strings.TrimSpace(node.GetAttribute("type")))] {
+					m.minifyContent(document, node, api.LoaderJS)
+				}
+			}
+			elements.Free()
+		}
+	}
+}
+
+// minifyDocument minifies a document and returns a context telling the HTML
+// formatter which end tags and quotes to leave out.
+func minifyDocument(ctx context.Context, document *markup.Document) context.Context {
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	m := &minifier{ctx, siteConfig(ctx).Minify, inPath}
+	m.minify(document)
+	return context.WithValue(ctx, builder_context.SerializerOptionsContextKey, markup.HTML5SerializerOptions{
+		OmitEndTags:         m.config.EndTags,
+		OmitAttributeQuotes: m.config.Quotes,
+	})
+}
+
+func init() {
+	blockElements = make(map[string]bool)
+	for _, name := range strings.Split("html,head,body,title,meta,link,base,style,script,address,article,aside,blockquote,caption,colgroup,col,dd,details,dialog,div,dl,dt,fieldset,figcaption,figure,footer,form,h1,h2,h3,h4,h5,h6,header,hgroup,hr,legend,li,main,menu,nav,ol,optgroup,option,p,pre,search,section,summary,table,tbody,td,template,tfoot,th,thead,tr,ul", ",") {
+		blockElements[name] = true
+	}
+
+	booleanAttributes = make(map[string]bool)
+	for _, name := range strings.Split("allowfullscreen,async,autofocus,autoplay,checked,controls,default,defer,disabled,formnovalidate,inert,ismap,itemscope,loop,multiple,muted,nomodule,novalidate,open,playsinline,readonly,required,reversed,selected", ",") {
+		booleanAttributes[name] = true
+	}
+}
@@ -0,0 +1,118 @@
+package transformer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+const (
+	outputParseOptions markup.ParserOption = markup.XML_PARSE_NONET
+)
+
+func readOutputDocument(outPath string) (*markup.Document, error) {
+	doc := markup.ReadHTMLFile(outPath, outputParseOptions)
+	if doc == nil {
+		return nil, errors.New(fmt.Sprintf("unable to load output file %s", outPath))
+	}
+	return doc, nil
+}
+
+// writeOutputDocument writes a document back to an output file with the
+// serializer options the file was built with.
+func writeOutputDocument(ctx context.Context, doc *markup.Document, outPath string) error {
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	options := markup.HTML5SerializerOptions{}
+	if manifest, ok := ctx.Value(builder_context.ManifestContextKey).(*Manifest); ok {
+		if recorded, ok := manifest.SerializerOptions(outPath); ok {
+			options = recorded
+		}
+	}
+	serializer := markup.NewHTML5SerializerWithOptions(bufio.NewWriter(outFile), options)
+	return serializer.Serialize(doc)
+}
+
+// writeXMLDocument writes a document with the xml formatter of the build and
+// adds it to the manifest.
+func writeXMLDocument(ctx context.Context, document *markup.Document, outPath string) error {
+	lookup, ok := ctx.Value(builder_context.FormatterLookupContextKey).(func(string) func(context.Context) error)
+	if !ok {
+		return errors.New("missing formatter lookup")
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	formatCtx := context.WithValue(ctx, builder_context.DocumentContextKey, document)
+	formatCtx = context.WithValue(formatCtx, builder_context.OutPathContextKey, outPath)
+	if err := lookup(".xml")(formatCtx); err != nil {
+		return err
+	}
+	if manifest, ok := ctx.Value(builder_context.ManifestContextKey).(*Manifest); ok {
+		manifest.Add("", outPath)
+	}
+	return nil
+}
+
+// addTextChild adds an element with text content to node.
+func addTextChild(document *markup.Document, node *markup.Node, name string, content string) *markup.Node {
+	child := node.NewChild(nil, name, "")
+	child.AddChild(document.NewText(content).Node)
+	return child
+}
+
+// setTextContent replaces the children of node with a single text node.
+// Unlike SetContent the text is not parsed for entity references.
+func setTextContent(document *markup.Document, node *markup.Node, content string) {
+	node.SetContent("")
+	node.AddChild(document.NewText(content).Node)
+}
+
+// splitURL splits a reference into its path and its query and fragment suffix.
+func splitURL(ref string) (string, string) {
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		return ref[:i], ref[i:]
+	}
+	return ref, ""
+}
+
+// isLocalURL reports whether ref points into the output tree.
+func isLocalURL(ref string) bool {
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "//") {
+		return false
+	}
+	if u, err := url.Parse(ref); err != nil || u.Scheme != "" || u.Host != "" {
+		return false
+	}
+	return true
+}
+
+// resolveOutputURL returns the file a local reference in a document at docPath points to.
+func resolveOutputURL(ref string, docPath string, outRoot string) (string, bool) {
+	if !isLocalURL(ref) {
+		return "", false
+	}
+	refPath, _ := splitURL(ref)
+	if unescaped, err := url.PathUnescape(refPath); err == nil {
+		refPath = unescaped
+	}
+	if refPath == "" {
+		return docPath, true
+	}
+	if strings.HasPrefix(refPath, "/") {
+		return filepath.Join(outRoot, filepath.FromSlash(refPath)), true
+	}
+	return filepath.Join(filepath.Dir(docPath), filepath.FromSlash(refPath)), true
+}
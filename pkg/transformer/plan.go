@@ -0,0 +1,137 @@
+package transformer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Plan maps the input files of a site build to the output files they will be
+// built into. It is made before anything is built so that pages can link to
+// pages built after them. Markdown sources are mapped to the page they are
+// rendered into, and pages can be looked up by title.
+type Plan struct {
+	outputs map[string]string
+	titles  map[string]string
+	parent  *Plan
+}
+
+func NewPlan() *Plan {
+	return &Plan{outputs: make(map[string]string), titles: make(map[string]string)}
+}
+
+// NewLocalePlan returns the plan of the sections built for a locale. Files
+// it has no output for are looked up in parent.
+func NewLocalePlan(parent *Plan) *Plan {
+	plan := NewPlan()
+	plan.parent = parent
+	return plan
+}
+
+// Add records that inPath is built into outPath. HTML inputs are read for the
+// markdown sources they render.
+func (p *Plan) Add(rootPath string, inPath string, outPath string) {
+	p.outputs[inPath] = outPath
+	p.addTitle(strings.TrimSuffix(filepath.Base(inPath), filepath.Ext(inPath)), outPath)
+
+	if filepath.Ext(inPath) != ".html" {
+		return
+	}
+	document := markup.ReadHTMLFile(inPath, outputParseOptions)
+	if document == nil {
+		return
+	}
+	defer document.Free()
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	if elements := xpath.Eval("/html/head/title"); elements != nil {
+		for _, node := range elements.Results() {
+			p.addTitle(node.GetContent(), outPath)
+		}
+		elements.Free()
+	}
+	if elements := xpath.Eval("//*[@is='markdown-element' and @src]"); elements != nil {
+		for _, node := range elements.Results() {
+			src := node.GetAttribute("src")
+			sourcePath := filepath.Join(filepath.Dir(inPath), filepath.FromSlash(src))
+			if strings.HasPrefix(src, "/") {
+				sourcePath = filepath.Join(rootPath, filepath.FromSlash(src))
+			}
+			if _, ok := p.outputs[sourcePath]; !ok {
+				p.outputs[sourcePath] = outPath
+			}
+			p.addTitle(strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath)), outPath)
+			if title := frontmatterTitle(sourcePath); title != "" {
+				p.addTitle(title, outPath)
+			}
+		}
+		elements.Free()
+	}
+}
+
+func (p *Plan) addTitle(title string, outPath string) {
+	if title = strings.TrimSpace(title); title == "" {
+		return
+	}
+	key := slugify(title)
+	if _, ok := p.titles[key]; !ok {
+		p.titles[key] = outPath
+	}
+}
+
+// Output returns the output file of an input file or markdown source.
+func (p *Plan) Output(inPath string) (string, bool) {
+	outPath, ok := p.outputs[inPath]
+	if !ok && p.parent != nil {
+		return p.parent.Output(inPath)
+	}
+	return outPath, ok
+}
+
+// Lookup returns the output file of the page with a title or file name.
+func (p *Plan) Lookup(title string) (string, bool) {
+	outPath, ok := p.titles[slugify(title)]
+	if !ok && p.parent != nil {
+		return p.parent.Lookup(title)
+	}
+	return outPath, ok
+}
+
+// frontmatterTitle reads the title from the frontmatter of a markdown file.
+func frontmatterTitle(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, []byte("---")) {
+		return ""
+	}
+	lines := bytes.SplitN(data, []byte("\n"), 2)
+	if len(lines) < 2 {
+		return ""
+	}
+	end := bytes.Index(lines[1], []byte("\n---"))
+	if end < 0 {
+		return ""
+	}
+	var meta struct {
+		Title string `yaml:"title"`
+	}
+	if err := yaml.Unmarshal(lines[1][:end], &meta); err != nil {
+		return ""
+	}
+	return meta.Title
+}
+
+func buildPlan(ctx context.Context) *Plan {
+	if plan, ok := ctx.Value(builder_context.PlanContextKey).(*Plan); ok {
+		return plan
+	}
+	return NewPlan()
+}
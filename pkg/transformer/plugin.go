@@ -0,0 +1,438 @@
+package transformer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+// PluginProtocolVersion is the version of the plugin protocol. Plugins answer
+// the initialize request with the version they speak and are not used when it
+// differs.
+const PluginProtocolVersion int = 1
+
+const pluginShutdownTimeout = 5 * time.Second
+
+const defaultPluginTimeout int = 30
+
+// PluginConfig is an external program providing a transformation. Command is
+// looked up in the root when it contains a path separator, in PATH otherwise.
+// Format is the serialization of the documents exchanged with it, html or
+// xml, by default html for .html output files and xml for the others.
+// Timeout is the number of seconds the plugin may take to answer a request,
+// 30 by default; it is killed and started again for the next file when it
+// takes longer.
+type PluginConfig struct {
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	Format  string   `yaml:"format"`
+	Timeout int      `yaml:"timeout"`
+}
+
+// Plugin messages are JSON-RPC 2.0 requests and responses, one per line, on
+// the standard input and output of the plugin process.
+type pluginMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int            `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  interface{}     `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *pluginError    `json:"error,omitempty"`
+}
+
+type pluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type pluginInitializeParams struct {
+	Protocol int    `json:"protocol"`
+	RootPath string `json:"rootPath"`
+}
+
+type pluginInitializeResult struct {
+	Protocol int    `json:"protocol"`
+	Name     string `json:"name"`
+}
+
+type pluginTransformParams struct {
+	Document string   `json:"document"`
+	Format   string   `json:"format"`
+	Args     []string `json:"args"`
+	InPath   string   `json:"inPath"`
+	OutPath  string   `json:"outPath"`
+	RootPath string   `json:"rootPath"`
+	Meta     Metadata `json:"meta"`
+}
+
+// pluginTransformResult is the answer to a transform request. A missing
+// document leaves the document unchanged and stop ends the pipeline.
+type pluginTransformResult struct {
+	Document    *string            `json:"document"`
+	Diagnostics []pluginDiagnostic `json:"diagnostics"`
+	Stop        bool               `json:"stop"`
+}
+
+type pluginDiagnostic struct {
+	Severity string `json:"severity"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Element  string `json:"element"`
+	Message  string `json:"message"`
+}
+
+type pluginLogParams struct {
+	Message string `json:"message"`
+}
+
+// pluginProcess is a running plugin. It handles the files of a build one
+// after the other.
+type pluginProcess struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	pipe    io.ReadCloser
+	stdout  *bufio.Reader
+	logger  *log.Logger
+	timeout time.Duration
+	nextID  int
+}
+
+func (p *pluginProcess) error(err error) error {
+	return errors.New(fmt.Sprintf("plugin %s: %s", p.name, err))
+}
+
+// call sends a request and waits for its response. Log notifications sent by
+// the plugin meanwhile are written to the logger. The process is killed when
+// the response does not come in time.
+func (p *pluginProcess) call(method string, params interface{}, result interface{}) error {
+	p.nextID++
+	id := p.nextID
+	request, err := json.Marshal(pluginMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return p.error(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+			done <- p.error(err)
+			return
+		}
+		done <- p.receive(id, result)
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// processes the plugin started may keep the pipes open, closing
+		// them ends the pending read and write
+		p.cmd.Process.Kill()
+		p.stdin.Close()
+		p.pipe.Close()
+		<-done
+		return p.error(errors.New(fmt.Sprintf("no response to %s after %s", method, p.timeout)))
+	}
+}
+
+// receive reads messages until the response to the request with id.
+func (p *pluginProcess) receive(id int, result interface{}) error {
+	for {
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return p.error(errors.New("process exited"))
+			}
+			return p.error(err)
+		}
+		if strings.TrimSpace(string(line)) == "" {
+			continue
+		}
+		var response pluginMessage
+		if err := json.Unmarshal(line, &response); err != nil {
+			return p.error(errors.New(fmt.Sprintf("invalid message: %s", err)))
+		}
+		if response.ID == nil {
+			p.notify(response)
+			continue
+		}
+		if *response.ID != id {
+			return p.error(errors.New(fmt.Sprintf("response to unknown request %d", *response.ID)))
+		}
+		if response.Error != nil {
+			return p.error(errors.New(response.Error.Message))
+		}
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return p.error(errors.New(fmt.Sprintf("invalid result: %s", err)))
+		}
+		return nil
+	}
+}
+
+func (p *pluginProcess) notify(message pluginMessage) {
+	if message.Method != "log" || p.logger == nil {
+		return
+	}
+	data, _ := json.Marshal(message.Params)
+	var params pluginLogParams
+	if err := json.Unmarshal(data, &params); err == nil {
+		p.logger.Printf("%s: %s\n", p.name, params.Message)
+	}
+}
+
+// close ends the input of the plugin, which exits when it reads the end of
+// it, and kills it when it does not exit in time.
+func (p *pluginProcess) close() error {
+	p.stdin.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- p.cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return p.error(err)
+		}
+		return nil
+	case <-time.After(pluginShutdownTimeout):
+		p.cmd.Process.Kill()
+		<-done
+		return p.error(errors.New("killed after not exiting"))
+	}
+}
+
+// Plugins starts the plugins of a site when they are first used and keeps
+// them running until the build is done.
+type Plugins struct {
+	mutex     sync.Mutex
+	configs   map[string]PluginConfig
+	rootPath  string
+	logger    *log.Logger
+	processes map[string]*pluginProcess
+}
+
+func NewPlugins(configs map[string]PluginConfig, rootPath string, logger *log.Logger) *Plugins {
+	return &Plugins{
+		configs:   configs,
+		rootPath:  rootPath,
+		logger:    logger,
+		processes: make(map[string]*pluginProcess),
+	}
+}
+
+// command is the path of the program of a plugin.
+func (p *Plugins) command(config PluginConfig) string {
+	if strings.ContainsRune(config.Command, '/') || strings.ContainsRune(config.Command, os.PathSeparator) {
+		if !filepath.IsAbs(config.Command) {
+			return filepath.Join(p.rootPath, config.Command)
+		}
+	}
+	return config.Command
+}
+
+func (p *Plugins) start(name string, config PluginConfig) (*pluginProcess, error) {
+	cmd := exec.Command(p.command(config), config.Args...)
+	cmd.Dir = p.rootPath
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.New(fmt.Sprintf("plugin %s: %s", name, err))
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultPluginTimeout
+	}
+	process := &pluginProcess{
+		name:    name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pipe:    stdout,
+		stdout:  bufio.NewReader(stdout),
+		logger:  p.logger,
+		timeout: time.Duration(timeout) * time.Second,
+	}
+	var result pluginInitializeResult
+	err = process.call("initialize", pluginInitializeParams{Protocol: PluginProtocolVersion, RootPath: p.rootPath}, &result)
+	if err == nil && result.Protocol != PluginProtocolVersion {
+		err = process.error(errors.New(fmt.Sprintf("unsupported protocol version %d, expected %d", result.Protocol, PluginProtocolVersion)))
+	}
+	if err != nil {
+		process.close()
+		return nil, err
+	}
+	return process, nil
+}
+
+// process returns the running process of a plugin, starting it if needed.
+func (p *Plugins) process(name string) (*pluginProcess, error) {
+	if process, ok := p.processes[name]; ok {
+		return process, nil
+	}
+	process, err := p.start(name, p.configs[name])
+	if err != nil {
+		return nil, err
+	}
+	p.processes[name] = process
+	return process, nil
+}
+
+// transform sends the document to a plugin. A plugin that fails or times out
+// is stopped and started again for the next file.
+func (p *Plugins) transform(name string, params pluginTransformParams) (*pluginTransformResult, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	process, err := p.process(name)
+	if err != nil {
+		return nil, err
+	}
+	var result pluginTransformResult
+	if err := process.call("transform", params, &result); err != nil {
+		delete(p.processes, name)
+		process.close()
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Close stops the running plugins.
+func (p *Plugins) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	messages := []string{}
+	for name, process := range p.processes {
+		if err := process.close(); err != nil {
+			messages = append(messages, err.Error())
+		}
+		delete(p.processes, name)
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n"))
+	}
+	return nil
+}
+
+// Lookup returns the transformation of a plugin, nil when no plugin has the
+// name.
+func (p *Plugins) Lookup(name string) TransformerFunc {
+	config, ok := p.configs[name]
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, args []string) (context.Context, Status, error) {
+		return p.run(ctx, name, config, args)
+	}
+}
+
+func pluginSeverity(severity string) Severity {
+	switch severity {
+	case "info":
+		return SeverityInfo
+	case "warning":
+		return SeverityWarning
+	default:
+		return SeverityError
+	}
+}
+
+func (p *Plugins) run(ctx context.Context, name string, config PluginConfig, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New(fmt.Sprintf("missing input document to plugin %s", name))
+	}
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	outPath, _ := ctx.Value(builder_context.OutPathContextKey).(string)
+
+	if command := p.command(config); filepath.IsAbs(command) {
+		addDependency(ctx, command)
+	}
+
+	format := config.Format
+	if format == "" {
+		format = "xml"
+		if ext := filepath.Ext(outPath); ext == ".html" || ext == ".htm" {
+			format = "html"
+		}
+	}
+	params := pluginTransformParams{
+		Format:   format,
+		Args:     args,
+		InPath:   inPath,
+		OutPath:  outPath,
+		RootPath: p.rootPath,
+		Meta:     documentMetadata(ctx),
+	}
+	switch format {
+	case "html":
+		html, err := serializeHTML(document, nil)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		params.Document = string(html)
+	case "xml":
+		params.Document = document.String()
+	default:
+		return ctx, Continue, errors.New(fmt.Sprintf("unknown format of plugin %s: %s", name, format))
+	}
+
+	result, err := p.transform(name, params)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	for _, diagnostic := range result.Diagnostics {
+		ReportDiagnostic(ctx, Diagnostic{
+			Severity: pluginSeverity(diagnostic.Severity),
+			File:     inPath,
+			Line:     diagnostic.Line,
+			Column:   diagnostic.Column,
+			Element:  diagnostic.Element,
+			Message:  diagnostic.Message,
+		})
+	}
+
+	status := Continue
+	if result.Stop {
+		status = Stop
+	}
+	if result.Document == nil {
+		return ctx, status, nil
+	}
+
+	var transformed *markup.Document
+	if format == "html" {
+		transformed = markup.ReadHTMLMemory([]byte(*result.Document), outputParseOptions)
+	} else {
+		transformed = markup.ReadMemory([]byte(*result.Document), inPath, "UTF-8", outputParseOptions)
+	}
+	if transformed == nil {
+		return ctx, Continue, errors.New(fmt.Sprintf("unable to parse result of plugin %s", name))
+	}
+	document.Free()
+	return context.WithValue(ctx, builder_context.DocumentContextKey, transformed), status, nil
+}
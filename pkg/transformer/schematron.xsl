@@ -0,0 +1,103 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<!--
+  Compiles an ISO Schematron schema into an XSLT 1.0 stylesheet that writes
+  the failed assertions and successful reports of a document as SVRL.
+  Supported are patterns, rules, abstract rules with extends, asserts,
+  reports, lets, value-of and name. Phases, abstract patterns and includes
+  are not.
+-->
+<xsl:stylesheet version="1.0"
+  xmlns:xsl="http://www.w3.org/1999/XSL/Transform"
+  xmlns:axsl="http://www.w3.org/1999/XSL/TransformAlias"
+  xmlns:sch="http://purl.oclc.org/dsdl/schematron"
+  xmlns:svrl="http://purl.oclc.org/dsdl/svrl"
+  exclude-result-prefixes="sch">
+
+  <xsl:namespace-alias stylesheet-prefix="axsl" result-prefix="xsl"/>
+
+  <xsl:template match="/sch:schema">
+    <axsl:stylesheet version="1.0">
+      <xsl:apply-templates select="sch:let"/>
+
+      <axsl:template match="/">
+        <svrl:schematron-output>
+          <xsl:for-each select="sch:pattern[not(@abstract = 'true')]">
+            <axsl:apply-templates select="/" mode="M{position()}"/>
+          </xsl:for-each>
+        </svrl:schematron-output>
+      </axsl:template>
+
+      <xsl:for-each select="sch:pattern[not(@abstract = 'true')]">
+        <xsl:variable name="mode" select="concat('M', position())"/>
+        <xsl:for-each select="sch:rule[not(@abstract = 'true')]">
+          <axsl:template match="{@context}" priority="{1000 - position()}" mode="{$mode}">
+            <xsl:apply-templates select="../sch:let | sch:let"/>
+            <xsl:apply-templates select="sch:assert | sch:report | sch:extends"/>
+            <axsl:apply-templates select="@*|node()" mode="{$mode}"/>
+          </axsl:template>
+        </xsl:for-each>
+        <axsl:template match="text()" priority="-1" mode="{$mode}"/>
+        <axsl:template match="@*|node()" priority="-2" mode="{$mode}">
+          <axsl:apply-templates select="@*|node()" mode="{$mode}"/>
+        </axsl:template>
+      </xsl:for-each>
+
+      <axsl:template name="location">
+        <axsl:for-each select="ancestor-or-self::*">/*[<axsl:value-of select="count(preceding-sibling::*) + 1"/>]</axsl:for-each>
+        <axsl:if test="count(. | ../@*) = count(../@*)">/@*[local-name()='<axsl:value-of select="local-name()"/>']</axsl:if>
+      </axsl:template>
+    </axsl:stylesheet>
+  </xsl:template>
+
+  <xsl:template match="sch:let">
+    <axsl:variable name="{@name}" select="{@value}"/>
+  </xsl:template>
+
+  <xsl:template match="sch:extends">
+    <xsl:apply-templates select="/sch:schema//sch:rule[@abstract = 'true'][@id = current()/@rule]/*[self::sch:assert or self::sch:report or self::sch:extends]"/>
+  </xsl:template>
+
+  <xsl:template match="sch:assert">
+    <axsl:if test="not({@test})">
+      <svrl:failed-assert>
+        <xsl:call-template name="result"/>
+      </svrl:failed-assert>
+    </axsl:if>
+  </xsl:template>
+
+  <xsl:template match="sch:report">
+    <axsl:if test="{@test}">
+      <svrl:successful-report>
+        <xsl:call-template name="result"/>
+      </svrl:successful-report>
+    </axsl:if>
+  </xsl:template>
+
+  <xsl:template name="result">
+    <xsl:attribute name="test"><xsl:value-of select="@test"/></xsl:attribute>
+    <xsl:if test="@role">
+      <xsl:attribute name="role"><xsl:value-of select="@role"/></xsl:attribute>
+    </xsl:if>
+    <axsl:attribute name="location">
+      <axsl:call-template name="location"/>
+    </axsl:attribute>
+    <svrl:text>
+      <xsl:apply-templates mode="text"/>
+    </svrl:text>
+  </xsl:template>
+
+  <xsl:template match="sch:value-of" mode="text">
+    <axsl:value-of select="{@select}"/>
+  </xsl:template>
+
+  <xsl:template match="sch:name" mode="text">
+    <xsl:choose>
+      <xsl:when test="@path">
+        <axsl:value-of select="name({@path})"/>
+      </xsl:when>
+      <xsl:otherwise>
+        <axsl:value-of select="name()"/>
+      </xsl:otherwise>
+    </xsl:choose>
+  </xsl:template>
+</xsl:stylesheet>
@@ -0,0 +1,461 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+
+	"github.com/dop251/goja"
+)
+
+const defaultScriptTimeout int = 10
+
+// ScriptConfig is the configuration of the script transformation. Timeout is
+// the number of seconds a script may run, 10 by default.
+type ScriptConfig struct {
+	Timeout int `yaml:"timeout"`
+}
+
+// scriptRuntime runs a script against a document. The nodes handed to the
+// script are wrapped in objects with a DOM-like API.
+type scriptRuntime struct {
+	ctx      context.Context
+	vm       *goja.Runtime
+	document *markup.Document
+	xpath    *markup.XPathContext
+	rootPath string
+	inPath   string
+	nodes    map[*goja.Object]*markup.Node
+	detached []*markup.Node
+}
+
+// insideRoot resolves a path relative to the root and fails for paths
+// outside it.
+func insideRoot(rootPath string, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootPath, path)
+	}
+	path = filepath.Clean(path)
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return "", err
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(os.PathSeparator)) {
+		return "", errors.New(fmt.Sprintf("path outside the project root: %s", path))
+	}
+	return resolved, nil
+}
+
+func (r *scriptRuntime) throw(err error) {
+	panic(r.vm.NewGoError(err))
+}
+
+// node returns the node of a wrapper object, or the text of a text node
+// wrapper or of any other value.
+func (r *scriptRuntime) node(value goja.Value) (*markup.Node, string) {
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		r.throw(errors.New("missing node"))
+	}
+	if object, ok := value.(*goja.Object); ok {
+		if node, ok := r.nodes[object]; ok {
+			if node.Type() == markup.XML_TEXT_NODE || node.Type() == markup.XML_CDATA_SECTION_NODE {
+				r.unlink(node)
+				return nil, node.GetContent()
+			}
+			return node, ""
+		}
+	}
+	return nil, value.String()
+}
+
+// insert adds a node next to or into target with add. Text is added as a new
+// text node, as libxml2 frees text nodes it merges with an adjacent one. Like
+// the DOM it fails for target and its ancestors, which would make a cycle.
+func (r *scriptRuntime) insert(target *markup.Node, value goja.Value, add func(node *markup.Node)) {
+	node, text := r.node(value)
+	if node == nil {
+		add(r.document.NewText(text).Node)
+		return
+	}
+	for ancestor := target; ancestor != nil; ancestor = ancestor.Parent() {
+		if ancestor.Ptr == node.Ptr {
+			r.throw(errors.New("hierarchy request error: the node contains the target"))
+		}
+	}
+	node.Unlink()
+	add(node)
+}
+
+func (r *scriptRuntime) nodeList(nodes []*markup.Node) []interface{} {
+	list := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		list[i] = r.wrap(node)
+	}
+	return list
+}
+
+func (r *scriptRuntime) eval(expression string) []*markup.Node {
+	result := r.xpath.Eval(expression)
+	if result == nil {
+		r.throw(errors.New(fmt.Sprintf("invalid xpath expression: %s", expression)))
+	}
+	defer result.Free()
+	return result.Results()
+}
+
+// query evaluates an XPath expression with node as the context node, so that
+// relative expressions start from it.
+func (r *scriptRuntime) query(node *markup.Node, expression string) []interface{} {
+	r.xpath.SetNode(node)
+	defer r.xpath.SetNode(nil)
+	return r.nodeList(r.eval(expression))
+}
+
+// selectCSS returns the descendants of node matching a CSS selector.
+func (r *scriptRuntime) selectCSS(node *markup.Node, selector string) []interface{} {
+	expression, err := cssToXPath(selector)
+	if err != nil {
+		r.throw(err)
+	}
+	return r.query(node, expression)
+}
+
+func (r *scriptRuntime) first(list []interface{}) interface{} {
+	if len(list) == 0 {
+		return nil
+	}
+	return list[0]
+}
+
+func nodeTypeName(node *markup.Node) string {
+	switch node.Type() {
+	case markup.XML_ELEMENT_NODE:
+		return "element"
+	case markup.XML_TEXT_NODE, markup.XML_CDATA_SECTION_NODE:
+		return "text"
+	case markup.XML_COMMENT_NODE:
+		return "comment"
+	case markup.XML_DOCUMENT_NODE, markup.XML_HTML_DOCUMENT_NODE:
+		return "document"
+	default:
+		return "other"
+	}
+}
+
+// unlink detaches a node; it is freed after the script unless it is added
+// to the document again. Text nodes are never added again, see insert.
+func (r *scriptRuntime) unlink(node *markup.Node) {
+	node.Unlink()
+	r.detached = append(r.detached, node)
+}
+
+// parseHTML replaces the children of a node with parsed HTML.
+func (r *scriptRuntime) parseHTML(node *markup.Node, html string) {
+	for _, child := range children(node) {
+		r.unlink(child)
+	}
+	parser := markup.CreateHTML5Parser(r.document, node)
+	if parser == nil {
+		r.throw(errors.New("unable to parse html"))
+	}
+	defer parser.Free()
+	if parser.ParseChunk(html) < 0 || parser.Terminate() < 0 {
+		r.throw(errors.New("unable to parse html"))
+	}
+}
+
+func (r *scriptRuntime) wrap(node *markup.Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	object := r.vm.NewObject()
+	r.nodes[object] = node
+
+	object.Set("name", node.Name())
+	object.Set("type", nodeTypeName(node))
+	object.Set("path", func() string {
+		return node.Path()
+	})
+	object.Set("getAttribute", func(name string) interface{} {
+		if node.HasAttribute(name) == nil {
+			return nil
+		}
+		return node.GetAttribute(name)
+	})
+	object.Set("hasAttribute", func(name string) bool {
+		return node.HasAttribute(name) != nil
+	})
+	object.Set("setAttribute", func(name string, value string) {
+		node.SetAttribute(name, value)
+	})
+	object.Set("removeAttribute", func(name string) {
+		node.UnsetAttribute(name)
+	})
+	object.Set("attributes", func() map[string]string {
+		attributes := make(map[string]string)
+		for attr := node.Attributes(); attr != nil; attr = attr.Next() {
+			attributes[attr.Name()] = node.GetAttribute(attr.Name())
+		}
+		return attributes
+	})
+	object.Set("text", func() string {
+		return node.GetContent()
+	})
+	object.Set("setText", func(text string) {
+		for _, child := range children(node) {
+			r.unlink(child)
+		}
+		setTextContent(r.document, node, text)
+	})
+	object.Set("html", func() string {
+		html, err := serializeHTML(r.document, node)
+		if err != nil {
+			r.throw(err)
+		}
+		return string(html)
+	})
+	object.Set("setHTML", func(html string) {
+		r.parseHTML(node, html)
+	})
+	object.Set("parent", func() interface{} {
+		if parent := node.Parent(); parent != nil && parent.Type() == markup.XML_ELEMENT_NODE {
+			return r.wrap(parent)
+		}
+		return nil
+	})
+	object.Set("children", func() []interface{} {
+		elements := []*markup.Node{}
+		for _, child := range children(node) {
+			if child.Type() == markup.XML_ELEMENT_NODE {
+				elements = append(elements, child)
+			}
+		}
+		return r.nodeList(elements)
+	})
+	object.Set("query", func(expression string) []interface{} {
+		return r.query(node, expression)
+	})
+	object.Set("select", func(selector string) []interface{} {
+		return r.selectCSS(node, selector)
+	})
+	object.Set("first", func(selector string) interface{} {
+		return r.first(r.selectCSS(node, selector))
+	})
+	object.Set("append", func(value goja.Value) {
+		r.insert(node, value, func(child *markup.Node) {
+			node.AddChild(child)
+		})
+	})
+	object.Set("prepend", func(value goja.Value) {
+		r.insert(node, value, func(child *markup.Node) {
+			if first := node.Children(); first != nil {
+				first.AddPrevSibling(child)
+			} else {
+				node.AddChild(child)
+			}
+		})
+	})
+	object.Set("before", func(value goja.Value) {
+		r.insert(node, value, func(sibling *markup.Node) {
+			node.AddPrevSibling(sibling)
+		})
+	})
+	object.Set("after", func(value goja.Value) {
+		r.insert(node, value, func(sibling *markup.Node) {
+			node.AddNextSibling(sibling)
+		})
+	})
+	object.Set("replaceWith", func(value goja.Value) {
+		r.insert(node, value, func(other *markup.Node) {
+			node.AddPrevSibling(other)
+		})
+		r.unlink(node)
+	})
+	object.Set("remove", func() {
+		r.unlink(node)
+	})
+	return object
+}
+
+// documentObject is the document global of a script.
+func (r *scriptRuntime) documentObject() *goja.Object {
+	object := r.vm.NewObject()
+	object.Set("root", func() interface{} {
+		return r.wrap(r.document.Root())
+	})
+	object.Set("query", func(expression string) []interface{} {
+		return r.query(r.document.Node, expression)
+	})
+	object.Set("select", func(selector string) []interface{} {
+		return r.selectCSS(r.document.Node, selector)
+	})
+	object.Set("first", func(selector string) interface{} {
+		return r.first(r.selectCSS(r.document.Node, selector))
+	})
+	object.Set("createElement", func(name string) interface{} {
+		node := r.document.NewNode(nil, name, "")
+		r.detached = append(r.detached, node)
+		return r.wrap(node)
+	})
+	object.Set("createText", func(text string) interface{} {
+		node := r.document.NewText(text).Node
+		r.detached = append(r.detached, node)
+		return r.wrap(node)
+	})
+	object.Set("createComment", func(text string) interface{} {
+		node := r.document.NewComment(text)
+		r.detached = append(r.detached, node)
+		return r.wrap(node)
+	})
+	return object
+}
+
+// console logs messages; warnings and errors are reported as diagnostics.
+func (r *scriptRuntime) consoleObject() *goja.Object {
+	logger, _ := r.ctx.Value(builder_context.LoggerContextKey).(*log.Logger)
+	message := func(call goja.FunctionCall) string {
+		parts := make([]string, len(call.Arguments))
+		for i, argument := range call.Arguments {
+			parts[i] = argument.String()
+		}
+		return strings.Join(parts, " ")
+	}
+	report := func(severity Severity) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			ReportDiagnostic(r.ctx, Diagnostic{Severity: severity, File: r.inPath, Message: message(call)})
+			return goja.Undefined()
+		}
+	}
+
+	object := r.vm.NewObject()
+	object.Set("log", func(call goja.FunctionCall) goja.Value {
+		if logger != nil {
+			logger.Println(message(call))
+		}
+		return goja.Undefined()
+	})
+	object.Set("info", report(SeverityInfo))
+	object.Set("warn", report(SeverityWarning))
+	object.Set("error", report(SeverityError))
+	return object
+}
+
+// free frees the nodes detached by the script that were not added to the
+// document again.
+func (r *scriptRuntime) free() {
+	roots := make(map[interface{}]*markup.Node)
+	for _, node := range r.detached {
+		if node.Parent() == nil {
+			roots[node.Ptr] = node
+		}
+	}
+	for _, node := range roots {
+		node.Free()
+	}
+	r.detached = nil
+}
+
+func loadScript(ctx context.Context, path string) (*goja.Program, error) {
+	value, err := buildState(ctx).Load("script:"+path, func() (interface{}, error) {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return goja.Compile(path, string(source), true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*goja.Program), nil
+}
+
+// TransformScript runs a JavaScript file, relative to the root, against the
+// document. Scripts see the document, the build paths, the metadata and the
+// remaining argument, and may read files inside the root only.
+func TransformScript(ctx context.Context, args []string) (context.Context, Status, error) {
+	if len(args) < 1 || args[0] == "" {
+		return ctx, Continue, errors.New("missing argument for script transform")
+	}
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document to script transform")
+	}
+	rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	outPath, _ := ctx.Value(builder_context.OutPathContextKey).(string)
+
+	path, err := insideRoot(rootPath, args[0])
+	if err != nil {
+		return ctx, Continue, err
+	}
+	program, err := loadScript(ctx, path)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	addDependency(ctx, path)
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	r := &scriptRuntime{
+		ctx:      ctx,
+		vm:       goja.New(),
+		document: document,
+		xpath:    xpath,
+		rootPath: rootPath,
+		inPath:   inPath,
+		nodes:    make(map[*goja.Object]*markup.Node),
+	}
+	defer r.free()
+
+	build := r.vm.NewObject()
+	build.Set("inPath", inPath)
+	build.Set("outPath", outPath)
+	build.Set("rootPath", rootPath)
+	build.Set("args", strings.Join(args[1:], ":"))
+	build.Set("meta", map[string]interface{}(documentMetadata(ctx)))
+	r.vm.Set("build", build)
+	r.vm.Set("document", r.documentObject())
+	r.vm.Set("console", r.consoleObject())
+	r.vm.Set("readFile", func(name string) string {
+		file, err := insideRoot(rootPath, name)
+		if err != nil {
+			r.throw(err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			r.throw(err)
+		}
+		return string(data)
+	})
+
+	timeout := siteConfig(ctx).Script.Timeout
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	timer := time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+		r.vm.Interrupt(fmt.Sprintf("script ran longer than %d seconds", timeout))
+	})
+	defer timer.Stop()
+
+	if _, err := r.vm.RunProgram(program); err != nil {
+		return ctx, Continue, errors.New(fmt.Sprintf("%s: %s", args[0], err))
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("script", TransformScript)
+}
@@ -0,0 +1,231 @@
+package transformer
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"gostatic/pkg/markup"
+)
+
+const (
+	defaultSearchOutput  string = "search-index.json"
+	defaultSearchScript  string = "search.js"
+	defaultSearchSnippet int    = 200
+	minSearchToken       int    = 2
+)
+
+var (
+	//go:embed search.js
+	searchScript string
+
+	defaultSearchExclude = []string{"//script", "//style", "//template", "//noscript", "//nav", "/html/body/header", "/html/body/footer", "//*[@data-search-ignore]"}
+	defaultSearchBoost   = map[string]float64{"title": 10, "headings": 5, "text": 1}
+	searchFields         = []string{"title", "headings", "text"}
+)
+
+// SearchConfig is the configuration of the search-index step. Output and
+// script are written relative to the output root. Pages is a glob pattern of
+// the pages to index, exclude lists XPath expressions of elements left out of
+// the index and boost weighs the title, headings and text fields.
+type SearchConfig struct {
+	Output  string             `yaml:"output"`
+	Script  string             `yaml:"script"`
+	Pages   string             `yaml:"pages"`
+	Exclude []string           `yaml:"exclude"`
+	Boost   map[string]float64 `yaml:"boost"`
+}
+
+type searchDocument struct {
+	URL     string `json:"u"`
+	Title   string `json:"t"`
+	Snippet string `json:"s,omitempty"`
+}
+
+// searchIndex maps a token to a flat list of document numbers and scores.
+type searchIndex struct {
+	Docs  []searchDocument     `json:"docs"`
+	Index map[string][]float64 `json:"index"`
+}
+
+// tokenize splits text into lower case words the way the search client does.
+func tokenize(text string) []string {
+	tokens := []string{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(token)) >= minSearchToken {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func selectTexts(xpath *markup.XPathContext, expression string) []string {
+	texts := []string{}
+	result := xpath.Eval(expression)
+	if result == nil {
+		return texts
+	}
+	defer result.Free()
+	for _, node := range result.Results() {
+		if text := strings.Join(strings.Fields(node.GetContent()), " "); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+// add indexes a page, unless it opts out with a robots noindex or a
+// search meta element.
+func (index *searchIndex) add(outPath string, url string, config SearchConfig) error {
+	document, err := readOutputDocument(outPath)
+	if err != nil {
+		return err
+	}
+	defer document.Free()
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	if optedOut(xpath, "search") {
+		return nil
+	}
+	for _, expression := range config.Exclude {
+		if elements := xpath.Eval(expression); elements != nil {
+			// every match is unlinked before any is freed, matches nested in
+			// other matches included
+			nodes := elements.Results()
+			for _, node := range nodes {
+				node.Unlink()
+			}
+			for _, node := range nodes {
+				if node.Parent() == nil {
+					node.Free()
+				}
+			}
+			elements.Free()
+		}
+	}
+
+	title := strings.Join(selectTexts(xpath, "/html/head/title"), " ")
+	fields := map[string]string{
+		"title":    title,
+		"headings": strings.Join(selectTexts(xpath, "//body//*[self::h1 or self::h2 or self::h3 or self::h4 or self::h5 or self::h6]"), " "),
+		"text":     strings.Join(selectTexts(xpath, "//body//text()"), " "),
+	}
+
+	snippet := fields["text"]
+	if runes := []rune(snippet); len(runes) > defaultSearchSnippet {
+		snippet = strings.TrimSpace(string(runes[:defaultSearchSnippet])) + "…"
+	}
+	doc := float64(len(index.Docs))
+	index.Docs = append(index.Docs, searchDocument{url, title, snippet})
+
+	scores := map[string]float64{}
+	for _, field := range searchFields {
+		for _, token := range tokenize(fields[field]) {
+			scores[token] += config.Boost[field]
+		}
+	}
+	for token, score := range scores {
+		index.Index[token] = append(index.Index[token], doc, math.Round(score*100)/100)
+	}
+	return nil
+}
+
+func writeSearchFile(ctx context.Context, outPath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return err
+	}
+	if manifest, err := buildManifest(ctx); err == nil {
+		manifest.Add("", outPath)
+	}
+	return nil
+}
+
+// TransformSearchIndex writes a full text search index of the HTML pages of
+// the build and a client script searching it. It is a step listed under
+// after.
+func TransformSearchIndex(ctx context.Context, args []string) (context.Context, Status, error) {
+	manifest, err := buildManifest(ctx)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	config := siteConfig(ctx).Search
+	if config.Output == "" {
+		config.Output = defaultSearchOutput
+	}
+	if config.Script == "" {
+		config.Script = defaultSearchScript
+	}
+	if config.Exclude == nil {
+		config.Exclude = defaultSearchExclude
+	}
+	boost := make(map[string]float64, len(defaultSearchBoost))
+	for field, value := range defaultSearchBoost {
+		boost[field] = value
+	}
+	for field, value := range config.Boost {
+		boost[field] = value
+	}
+	config.Boost = boost
+
+	outRoot := outputRoot(ctx)
+	outPath := filepath.Join(outRoot, config.Output)
+	scriptPath := filepath.Join(outRoot, config.Script)
+
+	index := &searchIndex{[]searchDocument{}, map[string][]float64{}}
+	for _, entry := range manifest.Entries() {
+		if filepath.Ext(entry.OutPath) != ".html" {
+			continue
+		}
+		relPath, err := filepath.Rel(outRoot, entry.OutPath)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			continue
+		}
+		if config.Pages != "" {
+			if ok, _ := filepath.Match(config.Pages, filepath.ToSlash(relPath)); !ok {
+				continue
+			}
+		}
+		url, err := pageURL("", outRoot, entry.OutPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		if err := index.add(entry.OutPath, url, config); err != nil {
+			return ctx, Continue, err
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	if err := writeSearchFile(ctx, outPath, data); err != nil {
+		return ctx, Continue, err
+	}
+
+	indexURL, err := filepath.Rel(filepath.Dir(scriptPath), outPath)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	script := strings.Replace(searchScript, "{{index}}", filepath.ToSlash(indexURL), 1)
+	if err := writeSearchFile(ctx, scriptPath, []byte(script)); err != nil {
+		return ctx, Continue, err
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("search-index", TransformSearchIndex)
+}
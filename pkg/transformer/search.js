@@ -0,0 +1,109 @@
+// search client written by the gostatic search-index step
+(function () {
+  "use strict";
+
+  var script = document.currentScript;
+  var indexURL = new URL("{{index}}", script ? script.src : document.baseURI);
+  var loading = null;
+
+  function tokenize(text) {
+    return text.toLowerCase().split(/[^\p{L}\p{N}]+/u).filter(function (token) {
+      return token.length >= 2;
+    });
+  }
+
+  function load() {
+    if (!loading) {
+      loading = fetch(indexURL).then(function (response) {
+        if (!response.ok) {
+          throw new Error("cannot load search index: " + response.status);
+        }
+        return response.json();
+      });
+    }
+    return loading;
+  }
+
+  // postings returns the scores of the documents containing token, the last
+  // token of a query also matches longer tokens with half the score.
+  function postings(index, token, prefix) {
+    var scores = {};
+    Object.keys(index.index).forEach(function (key) {
+      var factor = key === token ? 1 : (prefix && key.indexOf(token) === 0 ? 0.5 : 0);
+      if (!factor) {
+        return;
+      }
+      var list = index.index[key];
+      for (var i = 0; i < list.length; i += 2) {
+        scores[list[i]] = (scores[list[i]] || 0) + list[i + 1] * factor;
+      }
+    });
+    return scores;
+  }
+
+  function search(query) {
+    var tokens = tokenize(query);
+    return load().then(function (index) {
+      if (tokens.length === 0) {
+        return [];
+      }
+      var total = null;
+      tokens.forEach(function (token, i) {
+        var scores = postings(index, token, i === tokens.length - 1);
+        if (total === null) {
+          total = scores;
+          return;
+        }
+        Object.keys(total).forEach(function (doc) {
+          if (scores[doc] === undefined) {
+            delete total[doc];
+          } else {
+            total[doc] += scores[doc];
+          }
+        });
+      });
+      return Object.keys(total).map(function (doc) {
+        var page = index.docs[doc];
+        return { url: page.u, title: page.t, snippet: page.s, score: total[doc] };
+      }).sort(function (a, b) {
+        return b.score - a.score;
+      });
+    });
+  }
+
+  // inputs with a data-search attribute list their results in the element
+  // the attribute selects
+  function bind(input) {
+    var target = document.querySelector(input.getAttribute("data-search"));
+    if (!target) {
+      return;
+    }
+    input.addEventListener("input", function () {
+      var query = input.value;
+      search(query).then(function (results) {
+        if (input.value !== query) {
+          return;
+        }
+        target.textContent = "";
+        results.slice(0, 20).forEach(function (result) {
+          var item = document.createElement("li");
+          var link = document.createElement("a");
+          link.href = result.url;
+          link.textContent = result.title || result.url;
+          item.appendChild(link);
+          if (result.snippet) {
+            var snippet = document.createElement("p");
+            snippet.textContent = result.snippet;
+            item.appendChild(snippet);
+          }
+          target.appendChild(item);
+        });
+      });
+    });
+  }
+
+  window.gostaticSearch = search;
+  document.addEventListener("DOMContentLoaded", function () {
+    document.querySelectorAll("input[data-search]").forEach(bind);
+  });
+})();
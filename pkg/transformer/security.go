@@ -0,0 +1,319 @@
+package transformer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+const defaultSecurityHeaders string = "_headers"
+
+var defaultSecurityPolicy = map[string]string{
+	"default-src": "'self'",
+	"script-src":  "'self'",
+	"style-src":   "'self'",
+}
+
+// SecurityConfig is the configuration of the security transformation. CSP is
+// where the Content-Security-Policy of a page goes: a meta element (the
+// default), a headers file or nowhere. Policy lists the sources of each
+// directive, hashes of inline scripts and styles are added to them.
+type SecurityConfig struct {
+	Integrity *bool             `yaml:"integrity"`
+	CSP       string            `yaml:"csp"`
+	Policy    map[string]string `yaml:"policy"`
+	Headers   string            `yaml:"headers"`
+}
+
+// securityHeaders collects the policies of the pages of a build so that the
+// headers file lists all of them.
+type securityHeaders struct {
+	mutex    sync.Mutex
+	policies map[string]string
+}
+
+func (h *securityHeaders) write(headersPath string, urlPath string, policy string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.policies[urlPath] = policy
+	urlPaths := make([]string, 0, len(h.policies))
+	for urlPath := range h.policies {
+		urlPaths = append(urlPaths, urlPath)
+	}
+	sort.Strings(urlPaths)
+
+	var builder strings.Builder
+	for _, urlPath := range urlPaths {
+		builder.WriteString(urlPath)
+		builder.WriteString("\n  Content-Security-Policy: ")
+		builder.WriteString(h.policies[urlPath])
+		builder.WriteString("\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(headersPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(headersPath, []byte(builder.String()), 0644)
+}
+
+type securityPolicy struct {
+	directives map[string][]string
+}
+
+func newSecurityPolicy(config map[string]string) *securityPolicy {
+	if len(config) == 0 {
+		config = defaultSecurityPolicy
+	}
+	policy := &securityPolicy{directives: make(map[string][]string)}
+	for directive, sources := range config {
+		policy.directives[directive] = strings.Fields(sources)
+	}
+	return policy
+}
+
+func (p *securityPolicy) add(directive string, source string) {
+	sources, ok := p.directives[directive]
+	if !ok {
+		sources = []string{"'self'"}
+	}
+	for _, s := range sources {
+		if s == source {
+			return
+		}
+	}
+	p.directives[directive] = append(sources, source)
+}
+
+func (p *securityPolicy) String() string {
+	directives := make([]string, 0, len(p.directives))
+	for directive := range p.directives {
+		directives = append(directives, directive)
+	}
+	sort.Strings(directives)
+	for i, directive := range directives {
+		directives[i] = strings.TrimSpace(directive + " " + strings.Join(p.directives[directive], " "))
+	}
+	return strings.Join(directives, "; ")
+}
+
+func hashSource(algorithm string, data []byte) string {
+	if algorithm == "sha384" {
+		sum := sha512.Sum384(data)
+		return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256(data)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+type securer struct {
+	ctx     context.Context
+	outRoot string
+	mode    string
+	config  SecurityConfig
+}
+
+func newSecurer(ctx context.Context, args []string) (*securer, error) {
+	config := siteConfig(ctx).Security
+	mode := config.CSP
+	if len(args) > 0 && args[0] != "" {
+		mode = args[0]
+	}
+	switch mode {
+	case "":
+		mode = "meta"
+	case "meta", "headers", "none":
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown security policy mode: %s", mode))
+	}
+	return &securer{ctx, outputRoot(ctx), mode, config}, nil
+}
+
+// integrity adds the hash of local scripts and stylesheets to the elements
+// referring to them. The files are looked up in the output tree.
+func (s *securer) integrity(xpath *markup.XPathContext, inPath string, docPath string) {
+	elements := xpath.Eval("//script[@src] | //link[@href and (@rel='stylesheet' or @rel='modulepreload' or (@rel='preload' and (@as='script' or @as='style')))]")
+	if elements == nil {
+		return
+	}
+	defer elements.Free()
+
+	for _, node := range elements.Results() {
+		attr := "src"
+		if node.Name() == "link" {
+			attr = "href"
+		}
+		filePath, ok := resolveOutputURL(node.GetAttribute(attr), docPath, s.outRoot)
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			ReportDiagnostic(s.ctx, Diagnostic{
+				Severity: SeverityWarning,
+				File:     inPath,
+				Element:  node.Path(),
+				Message:  fmt.Sprintf("no integrity hash: %s", err),
+			})
+			continue
+		}
+		node.SetAttribute("integrity", hashSource("sha384", data))
+	}
+}
+
+// policy returns the policy of a document with the hashes of its inline
+// scripts and styles.
+func (s *securer) policy(xpath *markup.XPathContext) string {
+	policy := newSecurityPolicy(s.config.Policy)
+	for _, inline := range []struct {
+		expression string
+		directive  string
+	}{
+		{"//script[not(@src)]", "script-src"},
+		{"//style", "style-src"},
+	} {
+		if elements := xpath.Eval(inline.expression); elements != nil {
+			for _, node := range elements.Results() {
+				policy.add(inline.directive, "'"+hashSource("sha256", []byte(node.GetContent()))+"'")
+			}
+			elements.Free()
+		}
+	}
+	return policy.String()
+}
+
+func (s *securer) meta(document *markup.Document, xpath *markup.XPathContext, policy string) {
+	if elements := xpath.Eval("/html/head/meta[@http-equiv='Content-Security-Policy']"); elements != nil {
+		results := elements.Results()
+		elements.Free()
+		if len(results) > 0 {
+			results[0].SetAttribute("content", policy)
+			return
+		}
+	}
+	elements := xpath.Eval("/html/head")
+	if elements == nil {
+		return
+	}
+	defer elements.Free()
+	results := elements.Results()
+	if len(results) == 0 {
+		return
+	}
+	head := results[0]
+
+	meta := document.NewNode(nil, "meta", "")
+	meta.SetAttribute("http-equiv", "Content-Security-Policy")
+	meta.SetAttribute("content", policy)
+
+	// the policy only applies to elements after it, it goes right after the charset
+	if charsets := xpath.Eval("/html/head/meta[@charset]"); charsets != nil {
+		results := charsets.Results()
+		charsets.Free()
+		if len(results) > 0 {
+			results[0].AddNextSibling(meta)
+			return
+		}
+	}
+	if first := head.FirstChildNode(); first != nil {
+		first.AddPrevSibling(meta)
+	} else {
+		head.AddChild(meta)
+	}
+}
+
+func (s *securer) headers(docPath string, policy string) error {
+	headersPath := filepath.Join(s.outRoot, defaultSecurityHeaders)
+	if s.config.Headers != "" {
+		rootPath, _ := s.ctx.Value(builder_context.RootPathContextKey).(string)
+		headersPath = filepath.Join(rootPath, s.config.Headers)
+	}
+	value, err := buildState(s.ctx).Load("security.headers:"+headersPath, func() (interface{}, error) {
+		return &securityHeaders{policies: make(map[string]string)}, nil
+	})
+	if err != nil {
+		return err
+	}
+	relPath, err := filepath.Rel(s.outRoot, docPath)
+	if err != nil {
+		return err
+	}
+	urlPath := "/" + filepath.ToSlash(relPath)
+	if filepath.Base(urlPath) == "index.html" {
+		urlPath = strings.TrimSuffix(urlPath, "index.html")
+	}
+	return value.(*securityHeaders).write(headersPath, urlPath, policy)
+}
+
+func (s *securer) secure(document *markup.Document, inPath string, docPath string) error {
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	if s.config.Integrity == nil || *s.config.Integrity {
+		s.integrity(xpath, inPath, docPath)
+	}
+
+	switch s.mode {
+	case "meta":
+		s.meta(document, xpath, s.policy(xpath))
+	case "headers":
+		return s.headers(docPath, s.policy(xpath))
+	}
+	return nil
+}
+
+// TransformSecurity adds integrity hashes and a content security policy to the
+// current document or, in the after pipeline, to every html output file.
+func TransformSecurity(ctx context.Context, args []string) (context.Context, Status, error) {
+	s, err := newSecurer(ctx, args)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	if document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document); ok && document != nil {
+		inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+		outPath, ok := ctx.Value(builder_context.OutPathContextKey).(string)
+		if !ok || outPath == "-" {
+			return ctx, Continue, errors.New("security transformation needs an output file")
+		}
+		return ctx, Continue, s.secure(document, inPath, outPath)
+	}
+
+	manifest, err := buildManifest(ctx)
+	if err != nil {
+		return ctx, Continue, err
+	}
+	for _, entry := range manifest.Entries() {
+		if filepath.Ext(entry.OutPath) != ".html" {
+			continue
+		}
+		document, err := readOutputDocument(entry.OutPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		err = s.secure(document, entry.InPath, entry.OutPath)
+		if err == nil {
+			err = writeOutputDocument(ctx, document, entry.OutPath)
+		}
+		document.Free()
+		if err != nil {
+			return ctx, Continue, err
+		}
+	}
+
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("security", TransformSecurity)
+}
@@ -0,0 +1,204 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gostatic/pkg/markup"
+)
+
+const (
+	defaultSitemapOutput string = "sitemap.xml"
+	defaultSitemapLimit  int    = 50000
+	sitemapNamespace     string = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// SitemapConfig is the configuration of the sitemap step. Output is the
+// sitemap file relative to the output root. Sitemaps with more than limit
+// urls are split into numbered files listed by a sitemap index at output.
+type SitemapConfig struct {
+	Output  string   `yaml:"output"`
+	Limit   int      `yaml:"limit"`
+	Exclude []string `yaml:"exclude"`
+}
+
+func (c SitemapConfig) excludes(relPath string) bool {
+	for _, pattern := range c.Exclude {
+		if ok, _ := filepath.Match(pattern, relPath); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(relPath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type sitemapURL struct {
+	loc     string
+	lastmod string
+}
+
+// pageURL returns the absolute url of an output file, index.html files are
+// addressed by their directory.
+func pageURL(baseURL string, outRoot string, outPath string) (string, error) {
+	relPath, err := filepath.Rel(outRoot, outPath)
+	if err != nil {
+		return "", err
+	}
+	relPath = filepath.ToSlash(relPath)
+	if relPath == "index.html" {
+		relPath = ""
+	} else if strings.HasSuffix(relPath, "/index.html") {
+		relPath = strings.TrimSuffix(relPath, "index.html")
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + relPath, nil
+}
+
+// pageDate returns the date of a page in W3C format from its frontmatter, or
+// the modification time of its input file.
+func pageDate(entry ManifestEntry, keys ...string) string {
+	for _, key := range keys {
+		if value := entry.Meta.String(key); value != "" {
+			if _, ok := parseDate(value); ok {
+				return value
+			}
+		}
+	}
+	path := entry.InPath
+	if path == "" {
+		path = entry.OutPath
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime().UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+// optedOut reports whether a page opts out with a robots noindex meta element
+// or a meta element of the given name, e.g. from "sitemap: false" frontmatter.
+func optedOut(xpath *markup.XPathContext, name string) bool {
+	elements := xpath.Eval(fmt.Sprintf("/html/head/meta[(@name='robots' and contains(@content, 'noindex')) or (@name='%s' and (@content='false' or @content='no' or @content='exclude'))]", name))
+	if elements == nil {
+		return false
+	}
+	defer elements.Free()
+	return len(elements.Results()) > 0
+}
+
+func sitemapExcluded(outPath string) (bool, error) {
+	document, err := readOutputDocument(outPath)
+	if err != nil {
+		return false, err
+	}
+	defer document.Free()
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+	return optedOut(xpath, "sitemap"), nil
+}
+
+// writeSitemap writes a urlset or a sitemapindex document.
+func writeSitemap(ctx context.Context, outPath string, root string, child string, urls []sitemapURL) error {
+	document := markup.NewDoc("1.0")
+	defer document.Free()
+	node := document.NewNode(nil, root, "")
+	node.SetAttribute("xmlns", sitemapNamespace)
+	document.SetRoot(node)
+	for _, u := range urls {
+		item := node.NewChild(nil, child, "")
+		addTextChild(document, item, "loc", u.loc)
+		if u.lastmod != "" {
+			addTextChild(document, item, "lastmod", u.lastmod)
+		}
+	}
+	return writeXMLDocument(ctx, document, outPath)
+}
+
+// TransformSitemap writes a sitemap of the HTML pages of the build. It is a
+// step listed under after. The optional argument is the base url, which is
+// the site url by default.
+func TransformSitemap(ctx context.Context, args []string) (context.Context, Status, error) {
+	manifest, err := buildManifest(ctx)
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	config := siteConfig(ctx).Sitemap
+	baseURL := siteConfig(ctx).URL
+	if len(args) > 0 && args[0] != "" {
+		baseURL = strings.Join(args, ":")
+	}
+	if baseURL == "" {
+		return ctx, Continue, errors.New("sitemap needs the site url")
+	}
+	if config.Output == "" {
+		config.Output = defaultSitemapOutput
+	}
+	if config.Limit <= 0 || config.Limit > defaultSitemapLimit {
+		config.Limit = defaultSitemapLimit
+	}
+
+	outRoot := outputRoot(ctx)
+	urls := []sitemapURL{}
+	for _, entry := range manifest.Entries() {
+		if filepath.Ext(entry.OutPath) != ".html" {
+			continue
+		}
+		relPath, err := filepath.Rel(outRoot, entry.OutPath)
+		if err != nil || strings.HasPrefix(relPath, "..") || config.excludes(filepath.ToSlash(relPath)) {
+			continue
+		}
+		if excluded, err := sitemapExcluded(entry.OutPath); err != nil {
+			return ctx, Continue, err
+		} else if excluded {
+			continue
+		}
+		loc, err := pageURL(baseURL, outRoot, entry.OutPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		urls = append(urls, sitemapURL{loc, pageDate(entry, "lastmod", "updated", "date")})
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].loc < urls[j].loc })
+
+	outPath := filepath.Join(outRoot, config.Output)
+	if len(urls) <= config.Limit {
+		return ctx, Continue, writeSitemap(ctx, outPath, "urlset", "url", urls)
+	}
+
+	sitemaps := []sitemapURL{}
+	ext := filepath.Ext(outPath)
+	for i := 0; i < len(urls); i += config.Limit {
+		end := i + config.Limit
+		if end > len(urls) {
+			end = len(urls)
+		}
+		partPath := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(outPath, ext), len(sitemaps)+1, ext)
+		if err := writeSitemap(ctx, partPath, "urlset", "url", urls[i:end]); err != nil {
+			return ctx, Continue, err
+		}
+		loc, err := pageURL(baseURL, outRoot, partPath)
+		if err != nil {
+			return ctx, Continue, err
+		}
+		lastmod := ""
+		for _, u := range urls[i:end] {
+			if u.lastmod > lastmod {
+				lastmod = u.lastmod
+			}
+		}
+		sitemaps = append(sitemaps, sitemapURL{loc, lastmod})
+	}
+	return ctx, Continue, writeSitemap(ctx, outPath, "sitemapindex", "sitemap", sitemaps)
+}
+
+func init() {
+	Registry.Register("sitemap", TransformSitemap)
+}
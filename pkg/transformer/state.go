@@ -0,0 +1,70 @@
+package transformer
+
+import (
+	"context"
+	"sync"
+
+	builder_context "gostatic/pkg/builder/context"
+)
+
+// BuildState holds values that are computed once and shared by every file
+// of a site build. Values holding C memory register a cleanup to free it
+// when the build is done.
+type BuildState struct {
+	mutex    sync.Mutex
+	values   map[string]interface{}
+	cleanup  sync.Mutex
+	cleanups []func()
+}
+
+func NewBuildState() *BuildState {
+	return &BuildState{values: make(map[string]interface{})}
+}
+
+// Load returns the value stored under key, calling create to make it the
+// first time the key is used.
+func (s *BuildState) Load(key string, create func() (interface{}, error)) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if value, ok := s.values[key]; ok {
+		return value, nil
+	}
+	value, err := create()
+	if err != nil {
+		return nil, err
+	}
+	s.values[key] = value
+	return value, nil
+}
+
+// OnClose adds a function that is called when the build is done. It may be
+// called while a value is created.
+func (s *BuildState) OnClose(cleanup func()) {
+	s.cleanup.Lock()
+	defer s.cleanup.Unlock()
+
+	s.cleanups = append(s.cleanups, cleanup)
+}
+
+// Close calls the cleanup functions, the last added first, and forgets the
+// values.
+func (s *BuildState) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cleanup.Lock()
+	defer s.cleanup.Unlock()
+
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+	s.cleanups = nil
+	s.values = make(map[string]interface{})
+}
+
+func buildState(ctx context.Context) *BuildState {
+	if state, ok := ctx.Value(builder_context.StateContextKey).(*BuildState); ok {
+		return state
+	}
+	return NewBuildState()
+}
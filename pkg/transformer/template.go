@@ -46,6 +46,13 @@ func TransformTemplate(ctx context.Context, args []string) (context.Context, Sta
 		return ctx, Continue, errors.New("missing input document to template transform")
 	}
 	filename = args[0]
+	if filename == "layout" {
+		layout := documentMetadata(ctx).String("layout")
+		if layout == "" {
+			return ctx, Continue, errors.New("missing layout in document metadata")
+		}
+		filename = filepath.Join(ctx.Value(builder_context.RootPathContextKey).(string), layout)
+	}
 	if filename == "inline" {
 		style = markup.LoadStylesheetPI(document)
 		if style == nil {
@@ -72,6 +79,10 @@ func TransformTemplate(ctx context.Context, args []string) (context.Context, Sta
 	transformCtx := markup.NewTransformContext(style, document, logger)
 	defer transformCtx.Free()
 
+	if locale := documentLocale(ctx); locale != "" {
+		transformCtx.SetTranslator(&lazyTranslator{ctx: ctx, locale: locale})
+	}
+
 	transformation := transformCtx.ApplyStylesheet(style, document, params, strparams)
 	if transformation == nil {
 		return ctx, Continue, errors.New("error applying stylesheet")
@@ -0,0 +1,218 @@
+package transformer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+const (
+	defaultTocScope   string = "/"
+	defaultTocExclude string = "no-toc"
+)
+
+// TocConfig is the configuration of the toc transformation. Headings from
+// level min to max inside the elements selected by scope are listed, except
+// headings with (or inside an element with) one of the exclude classes.
+type TocConfig struct {
+	Scope   string   `yaml:"scope"`
+	Min     int      `yaml:"min"`
+	Max     int      `yaml:"max"`
+	Exclude []string `yaml:"exclude"`
+}
+
+type tocHeading struct {
+	level int
+	id    string
+	text  string
+}
+
+// slugify makes an id from heading text the way markdown heading ids are made.
+func slugify(text string) string {
+	var builder strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if dash && builder.Len() > 0 {
+				builder.WriteRune('-')
+			}
+			dash = false
+			builder.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-':
+			dash = true
+		}
+	}
+	if builder.Len() == 0 {
+		return "heading"
+	}
+	return builder.String()
+}
+
+// uniqueID returns id or id-n when id is already used in the document.
+func uniqueID(id string, ids map[string]bool) string {
+	unique := id
+	for n := 1; ids[unique]; n++ {
+		unique = id + "-" + strconv.Itoa(n)
+	}
+	ids[unique] = true
+	return unique
+}
+
+func tocExpression(scope string, min int, max int, exclude []string) string {
+	levels := []string{}
+	for level := min; level <= max; level++ {
+		levels = append(levels, fmt.Sprintf("self::h%d", level))
+	}
+	predicates := []string{
+		strings.Join(levels, " or "),
+		"not(ancestor::*[@is='toc-element'])",
+	}
+	for _, class := range exclude {
+		predicates = append(predicates, fmt.Sprintf("not(ancestor-or-self::*[contains(concat(' ', normalize-space(@class), ' '), ' %s ')])", class))
+	}
+	return fmt.Sprintf("(%s)/descendant-or-self::*[%s]", scope, strings.Join(predicates, " and "))
+}
+
+// tocList builds nested ordered lists of links to the headings.
+func tocList(document *markup.Document, headings []tocHeading) *markup.Node {
+	root := document.NewNode(nil, "ol", "")
+	lists := []*markup.Node{root}
+	levels := []int{headings[0].level}
+	var item *markup.Node
+	for _, heading := range headings {
+		for heading.level > levels[len(levels)-1] && item != nil {
+			list := document.NewNode(nil, "ol", "")
+			item.AddChild(list)
+			lists = append(lists, list)
+			levels = append(levels, heading.level)
+		}
+		for heading.level < levels[len(levels)-1] && len(levels) > 1 {
+			lists = lists[:len(lists)-1]
+			levels = levels[:len(levels)-1]
+		}
+		item = document.NewNode(nil, "li", "")
+		link := document.NewNode(nil, "a", "")
+		link.SetAttribute("href", "#"+heading.id)
+		setTextContent(document, link, heading.text)
+		item.AddChild(link)
+		lists[len(lists)-1].AddChild(item)
+	}
+	return root
+}
+
+func attributeInt(node *markup.Node, name string, value int) int {
+	if attr := node.GetAttribute(name); attr != "" {
+		if n, err := strconv.Atoi(attr); err == nil {
+			return n
+		}
+	}
+	return value
+}
+
+// TransformToc fills <nav is="toc-element"> placeholders with a table of
+// contents. The data-scope, data-min and data-max attributes of a placeholder
+// override the configuration. The optional argument is the scope.
+func TransformToc(ctx context.Context, args []string) (context.Context, Status, error) {
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document")
+	}
+
+	config := siteConfig(ctx).Toc
+	if len(args) > 0 && args[0] != "" {
+		config.Scope = strings.Join(args, ":")
+	}
+	if config.Scope == "" {
+		config.Scope = defaultTocScope
+	}
+	if config.Min <= 0 {
+		config.Min = 1
+	}
+	if config.Max <= 0 || config.Max > 6 {
+		config.Max = 6
+	}
+	if config.Exclude == nil {
+		config.Exclude = []string{defaultTocExclude}
+	}
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	placeholders := xpath.Eval("//*[@is='toc-element']")
+	if placeholders == nil {
+		return ctx, Continue, nil
+	}
+	defer placeholders.Free()
+	if len(placeholders.Results()) == 0 {
+		return ctx, Continue, nil
+	}
+
+	ids := make(map[string]bool)
+	if elements := xpath.Eval("//*[@id]"); elements != nil {
+		for _, node := range elements.Results() {
+			ids[node.GetAttribute("id")] = true
+		}
+		elements.Free()
+	}
+
+	// headings get an id, ids used by an earlier element are made unique
+	headingIDs := make(map[string]bool)
+	if elements := xpath.Eval("//*[self::h1 or self::h2 or self::h3 or self::h4 or self::h5 or self::h6]"); elements != nil {
+		for _, node := range elements.Results() {
+			id := node.GetAttribute("id")
+			if id == "" {
+				id = uniqueID(slugify(strings.TrimSpace(node.GetContent())), ids)
+				node.SetAttribute("id", id)
+			} else if headingIDs[id] {
+				id = uniqueID(id, ids)
+				node.SetAttribute("id", id)
+			}
+			headingIDs[id] = true
+		}
+		elements.Free()
+	}
+
+	for _, placeholder := range placeholders.Results() {
+		scope := placeholder.GetAttribute("data-scope")
+		if scope == "" {
+			scope = config.Scope
+		}
+		min := attributeInt(placeholder, "data-min", config.Min)
+		max := attributeInt(placeholder, "data-max", config.Max)
+
+		elements := xpath.Eval(tocExpression(scope, min, max, config.Exclude))
+		if elements == nil {
+			return ctx, Continue, errors.New(fmt.Sprintf("invalid toc scope: %s", scope))
+		}
+		headings := []tocHeading{}
+		for _, node := range elements.Results() {
+			level, _ := strconv.Atoi(strings.TrimPrefix(node.Name(), "h"))
+			text := strings.Join(strings.Fields(node.GetContent()), " ")
+			headings = append(headings, tocHeading{level, node.GetAttribute("id"), text})
+		}
+		elements.Free()
+
+		placeholder.SetContent("")
+		if len(headings) > 0 {
+			placeholder.AddChild(tocList(document, headings))
+		}
+		for _, name := range []string{"is", "data-scope", "data-min", "data-max"} {
+			if attr := placeholder.HasAttribute(name); attr != nil {
+				markup.RemoveAttribute(attr)
+			}
+		}
+	}
+
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("toc", TransformToc)
+}
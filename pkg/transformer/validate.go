@@ -0,0 +1,258 @@
+package transformer
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	builder_context "gostatic/pkg/builder/context"
+	"gostatic/pkg/markup"
+)
+
+const (
+	schematronNamespace string = "http://purl.oclc.org/dsdl/schematron"
+	svrlNamespace       string = "http://purl.oclc.org/dsdl/svrl"
+)
+
+var (
+	//go:embed schematron.xsl
+	schematronCompiler string
+
+	schematronSeverities = map[string]Severity{
+		"info":        SeverityInfo,
+		"information": SeverityInfo,
+		"warning":     SeverityWarning,
+		"warn":        SeverityWarning,
+	}
+)
+
+// ValidateConfig is the configuration of the validate transformation. With
+// fail the build fails when a document is invalid.
+type ValidateConfig struct {
+	Fail bool `yaml:"fail"`
+}
+
+// validator checks a document and returns the problems found.
+type validator func(document *markup.Document) []Diagnostic
+
+// errorDiagnostics converts the errors of a libxml2 parser or validator. The
+// element of an error is looked up only while its document is still alive.
+func errorDiagnostics(errs []*markup.Error, elements bool) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, len(errs))
+	for _, err := range errs {
+		severity := SeverityError
+		if err.Level() == markup.XML_ERR_WARNING {
+			severity = SeverityWarning
+		}
+		diagnostic := Diagnostic{
+			Severity: severity,
+			File:     err.File(),
+			Line:     err.Line(),
+			Column:   err.Column(),
+			Message:  strings.TrimSpace(err.String()),
+		}
+		if node := err.Node(); elements && node != nil {
+			diagnostic.Element = node.Path()
+		}
+		diagnostics = append(diagnostics, diagnostic)
+		err.Free()
+	}
+	return diagnostics
+}
+
+// reportSchemaErrors reports the errors and warnings of parsing a schema.
+func reportSchemaErrors(ctx context.Context, path string, errs []*markup.Error) {
+	for _, diagnostic := range errorDiagnostics(errs, false) {
+		if diagnostic.File == "" {
+			diagnostic.File = path
+		}
+		ReportDiagnostic(ctx, diagnostic)
+	}
+}
+
+// compileSchematron compiles a Schematron schema into a stylesheet. The
+// namespaces declared with sch:ns are added to the generated stylesheet.
+func compileSchematron(path string) (*markup.Stylesheet, error) {
+	compilerDoc := markup.ReadMemory([]byte(schematronCompiler), "schematron.xsl", "UTF-8", markup.XML_PARSE_NONET)
+	if compilerDoc == nil {
+		return nil, errors.New("unable to parse schematron compiler")
+	}
+	compiler := markup.ParseStylesheetDoc(compilerDoc)
+	if compiler == nil {
+		compilerDoc.Free()
+		return nil, errors.New("unable to parse schematron compiler")
+	}
+	defer compiler.Free()
+
+	schema := markup.ReadFile(path, "UTF-8", markup.XML_PARSE_NONET)
+	if schema == nil {
+		return nil, errors.New(fmt.Sprintf("unable to parse schema: %s", path))
+	}
+	defer schema.Free()
+
+	generated := markup.ApplyStylesheet(compiler, schema)
+	if generated == nil || generated.Root() == nil {
+		return nil, errors.New(fmt.Sprintf("unable to compile schematron schema: %s", path))
+	}
+
+	xpath := markup.NewXPathContext(schema)
+	defer xpath.Free()
+	if namespaces := xpath.Eval(fmt.Sprintf("/*/*[local-name()='ns' and namespace-uri()='%s']", schematronNamespace)); namespaces != nil {
+		for _, node := range namespaces.Results() {
+			generated.Root().NewNs(node.GetAttribute("uri"), node.GetAttribute("prefix"))
+		}
+		namespaces.Free()
+	}
+
+	style := markup.ParseStylesheetDoc(generated)
+	if style == nil {
+		generated.Free()
+		return nil, errors.New(fmt.Sprintf("unable to compile schematron schema: %s", path))
+	}
+	return style, nil
+}
+
+// schematronDiagnostics reads the failed asserts and successful reports of a
+// SVRL document. The line is that of the node at the reported location.
+func schematronDiagnostics(document *markup.Document, svrl *markup.Document) []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	results := markup.NewXPathContext(svrl)
+	defer results.Free()
+	elements := results.Eval(fmt.Sprintf("//*[namespace-uri()='%s'][local-name()='failed-assert' or local-name()='successful-report']", svrlNamespace))
+	if elements == nil {
+		return diagnostics
+	}
+	defer elements.Free()
+
+	xpath := markup.NewXPathContext(document)
+	defer xpath.Free()
+
+	for _, element := range elements.Results() {
+		severity, ok := schematronSeverities[strings.ToLower(element.GetAttribute("role"))]
+		if !ok {
+			severity = SeverityError
+		}
+		diagnostic := Diagnostic{
+			Severity: severity,
+			Message:  strings.Join(strings.Fields(element.GetContent()), " "),
+		}
+		if diagnostic.Message == "" {
+			diagnostic.Message = fmt.Sprintf("%s: %s", element.Name(), element.GetAttribute("test"))
+		}
+		if location := element.GetAttribute("location"); location != "" {
+			if nodes := xpath.Eval(location); nodes != nil {
+				if found := nodes.Results(); len(found) > 0 {
+					diagnostic.Line = found[0].Line()
+					diagnostic.Element = found[0].Path()
+				}
+				nodes.Free()
+			}
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
+
+// loadValidator parses a schema, the kind of which is told by its extension:
+// .dtd, .rng, .xsd or .sch for Schematron.
+func loadValidator(ctx context.Context, path string) (validator, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dtd":
+		dtd, errs := markup.ParseDTDFile(path)
+		reportSchemaErrors(ctx, path, errs)
+		if dtd == nil {
+			return nil, errors.New(fmt.Sprintf("unable to parse schema: %s", path))
+		}
+		buildState(ctx).OnClose(dtd.Free)
+		return func(document *markup.Document) []Diagnostic {
+			_, errs := dtd.Validate(document)
+			return errorDiagnostics(errs, true)
+		}, nil
+	case ".rng":
+		schema, errs := markup.ParseRelaxNGFile(path)
+		reportSchemaErrors(ctx, path, errs)
+		if schema == nil {
+			return nil, errors.New(fmt.Sprintf("unable to parse schema: %s", path))
+		}
+		buildState(ctx).OnClose(schema.Free)
+		return func(document *markup.Document) []Diagnostic {
+			_, errs := schema.Validate(document)
+			return errorDiagnostics(errs, true)
+		}, nil
+	case ".xsd":
+		schema, errs := markup.ParseSchemaFile(path)
+		reportSchemaErrors(ctx, path, errs)
+		if schema == nil {
+			return nil, errors.New(fmt.Sprintf("unable to parse schema: %s", path))
+		}
+		buildState(ctx).OnClose(schema.Free)
+		return func(document *markup.Document) []Diagnostic {
+			_, errs := schema.Validate(document)
+			return errorDiagnostics(errs, true)
+		}, nil
+	case ".sch":
+		style, err := compileSchematron(path)
+		if err != nil {
+			return nil, err
+		}
+		buildState(ctx).OnClose(style.Free)
+		return func(document *markup.Document) []Diagnostic {
+			svrl := markup.ApplyStylesheet(style, document)
+			if svrl == nil {
+				return []Diagnostic{{Severity: SeverityError, Message: fmt.Sprintf("unable to apply schematron schema: %s", path)}}
+			}
+			defer svrl.Free()
+			return schematronDiagnostics(document, svrl)
+		}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown schema type: %s", path))
+	}
+}
+
+// TransformValidate validates a document against the DTD, RELAX NG, XML
+// Schema or Schematron schema named by the argument, relative to the root.
+// Problems are reported as build diagnostics.
+func TransformValidate(ctx context.Context, args []string) (context.Context, Status, error) {
+	if len(args) < 1 || args[0] == "" {
+		return ctx, Continue, errors.New("missing schema argument to validate")
+	}
+	document, ok := ctx.Value(builder_context.DocumentContextKey).(*markup.Document)
+	if !ok || document == nil {
+		return ctx, Continue, errors.New("missing input document")
+	}
+	inPath, _ := ctx.Value(builder_context.InPathContextKey).(string)
+	rootPath, _ := ctx.Value(builder_context.RootPathContextKey).(string)
+
+	path := args[0]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootPath, path)
+	}
+	value, err := buildState(ctx).Load("validate:"+path, func() (interface{}, error) {
+		return loadValidator(ctx, path)
+	})
+	if err != nil {
+		return ctx, Continue, err
+	}
+
+	failed := 0
+	for _, diagnostic := range value.(validator)(document) {
+		diagnostic.File = inPath
+		ReportDiagnostic(ctx, diagnostic)
+		if diagnostic.Severity == SeverityError {
+			failed++
+		}
+	}
+
+	if siteConfig(ctx).Validate.Fail && failed > 0 {
+		return ctx, Continue, errors.New(fmt.Sprintf("%s: %d validation error(s) against %s", inPath, failed, args[0]))
+	}
+	return ctx, Continue, nil
+}
+
+func init() {
+	Registry.Register("validate", TransformValidate)
+}
@@ -32,6 +32,8 @@ func TransformWhitespace(ctx context.Context, args []string) (context.Context, S
 	switch subcommand {
 	case "normalize":
 		normalizeWhitespace(document)
+	case "minify":
+		ctx = minifyDocument(ctx, document)
 	default:
 		break
 	}